@@ -4,12 +4,44 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Metric   Metrics `yaml:"metrics"`
 	Bridge   `yaml:"bridge"`
 	Services []Service `yaml:"services"`
+	Sensors  []Sensor  `yaml:"sensors"`
+
+	// Bridges are several bridges, each with its own services, used instead of Bridge.
+	Bridges []Bridge `yaml:"bridges"`
+
+	// Accessories are accessories whose characteristics are read and written by commands.
+	Accessories []CustomAccessory `yaml:"accessories"`
+
+	// Groups are switches starting and stopping several services at once.
+	Groups []Group `yaml:"groups"`
+
+	// HistoryLines is the number of lines of output kept in memory for each service.
+	HistoryLines int `yaml:"history-lines"`
+
+	// LogFormat is either "text" (the default) or "json".
+	LogFormat string `yaml:"log-format"`
+
+	// TimestampFormat is the layout, as in time.Format, of the time written before each line of output in text format.
+	TimestampFormat string `yaml:"timestamp-format"`
+
+	// Color is either "auto" (the default), "always" or "never".
+	Color string `yaml:"color"`
+
+	// Redact is a list of regular expressions whose matches are masked in the output of services and hkswitch.
+	Redact []string `yaml:"redact"`
 }
 
 func (c *Config) ServiceNames() (list []string) {
@@ -33,18 +65,488 @@ type Bridge struct {
 	SerialNumber string `yaml:"serial-number"`
 	Model        string `yaml:"model"`
 	Firmware     string `yaml:"firmware"`
+
+	// IdentifyCommand is run when the bridge is identified from HomeKit.
+	IdentifyCommand []string `yaml:"identify-command,flow"`
+
+	// Services are the names of the services shown by the bridge, all of them when there is only one bridge and
+	// it's not set.
+	Services []string `yaml:"services"`
+}
+
+// StoragePath returns the directory where the bridge's state is stored: StorageDir, or its name when not set, like hc
+// does.
+func (b Bridge) StoragePath() string {
+	if b.StorageDir != "" {
+		return b.StorageDir
+	}
+
+	return b.Name
+}
+
+// BridgeConfigs returns a copy of the configuration for each bridge, whose Bridge is that bridge, with the names of
+// its services set. Sensors, accessories and groups are shown by the first bridge only.
+func (c Config) BridgeConfigs() []Config {
+	bridges := c.Bridges
+	if len(bridges) == 0 {
+		bridges = []Bridge{c.Bridge}
+	}
+
+	list := make([]Config, 0, len(bridges))
+
+	for i, bridge := range bridges {
+		cfg := c
+		cfg.Bridge = bridge
+		cfg.Bridges = nil
+
+		if len(bridges) == 1 && len(bridge.Services) == 0 {
+			cfg.Bridge.Services = c.ServiceNames()
+		}
+
+		if i > 0 {
+			cfg.Sensors = nil
+			cfg.Accessories = nil
+			cfg.Groups = nil
+		}
+
+		list = append(list, cfg)
+	}
+
+	return list
 }
 
 type Service struct {
+	// ID identifies the service's accessory in HomeKit, so that the service can be renamed keeping its accessory.
+	// The name is used when empty.
+	ID string `yaml:"id"`
+
+	// Accessory is the type of the service's accessory, one of the Accessory constants; a switch when empty.
+	Accessory string `yaml:"accessory"`
+
+	// ExitEvents adds a button to the accessory, pressed when the service stops, for use in automations.
+	ExitEvents bool `yaml:"exit-events"`
+
+	// Control is either ControlReadWrite, the default, or ControlReadOnly for services shown in HomeKit that can't be
+	// started or stopped from it.
+	Control string `yaml:"control"`
+
+	// Invert shows the service's accessory as on when the service is stopped: turning it on stops the service.
+	Invert bool `yaml:"invert"`
+
+	// IdentifyCommand is run, in the service's work dir and environment, when its accessory is identified from
+	// HomeKit. Its output goes where the service's goes.
+	IdentifyCommand []string `yaml:"identify-command,flow"`
+
 	Name       string   `yaml:"name"`
 	Command    []string `yaml:"command,flow"`
 	Autostart  bool     `yaml:"autostart"`
 	Workdir    string   `yaml:"work-dir"`
 	Env        []string `yaml:"env"`
 	StopSignal string   `yaml:"stop-signal"`
+
+	// Secrets are names of environment variables whose values are masked in the output of services and hkswitch.
+	Secrets []string `yaml:"secrets"`
+
+	KillOnParentDeath bool `yaml:"kill-on-parent-death"`
+
+	TTY     bool   `yaml:"tty"`
+	TTYRows uint16 `yaml:"tty-rows"`
+	TTYCols uint16 `yaml:"tty-cols"`
+
+	// Variants are the programs the service can run, one at a time, instead of Command. They are the inputs of a
+	// television accessory.
+	Variants []Variant `yaml:"variants"`
+
+	// Replicas, when set, lets HomeKit choose how many instances of the service run, with the brightness of a
+	// lightbulb or the rotation speed of a fan.
+	Replicas *Replicas `yaml:"replicas"`
+
+	// Progress, when set, shows the progress of the service's job, parsed from its output, on its accessory.
+	Progress *Progress `yaml:"progress"`
+
+	Log *Log `yaml:"log"`
+}
+
+// Control modes.
+const (
+	ControlReadWrite = "read-write"
+	ControlReadOnly  = "read-only"
+)
+
+// Progress displays.
+const (
+	ProgressBattery   = "battery"
+	ProgressLightbulb = "lightbulb"
+)
+
+// Progress is how the progress of a service's job is parsed from its output, and shown.
+type Progress struct {
+	// Pattern is a regular expression matching the lines reporting progress, besides HKSWITCH_PROGRESS=<percent>:
+	// its only group is the percentage, or its two groups are the number of steps done and the total.
+	Pattern string `yaml:"pattern"`
+
+	// Show is either ProgressBattery, the default, or ProgressLightbulb.
+	Show string `yaml:"show"`
+}
+
+// Regexp returns the compiled Pattern, nil when not set.
+func (p Progress) Regexp() *regexp.Regexp {
+	if p.Pattern == "" {
+		return nil
+	}
+
+	return regexp.MustCompile(p.Pattern)
+}
+
+// Replicas is the range of the number of instances of a service.
+type Replicas struct {
+	// Min is the number of instances started when the service is turned on, 1 when not set.
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// Lowest returns Min, or 1 when not set.
+func (r Replicas) Lowest() int {
+	if r.Min > 0 {
+		return r.Min
+	}
+
+	return 1
 }
 
-var DefaultConfig = Config{}
+// Count returns the number of instances for a level between 0 and 100, proportional to Max but not less than
+// Lowest, or 0 for level 0.
+func (r Replicas) Count(level float64) int {
+	if level <= 0 {
+		return 0
+	}
+
+	n := int(math.Ceil(level * float64(r.Max) / 100))
+	if n < r.Lowest() {
+		return r.Lowest()
+	}
+
+	if n > r.Max {
+		return r.Max
+	}
+
+	return n
+}
+
+// Level returns the level, between 0 and 100, of n instances.
+func (r Replicas) Level(n int) float64 {
+	return math.Min(100, float64(n)*100/float64(r.Max))
+}
+
+// Variant is one of the programs a service can run. Env is added to the service's environment.
+type Variant struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command,flow"`
+	Env     []string `yaml:"env"`
+}
+
+// Accessory types.
+const (
+	AccessorySwitch         = "switch"
+	AccessoryOutlet         = "outlet"
+	AccessoryFan            = "fan"
+	AccessoryLightbulb      = "lightbulb"
+	AccessoryValve          = "valve"
+	AccessoryAirPurifier    = "air-purifier"
+	AccessoryGarageDoor     = "garage-door"
+	AccessoryWindowCovering = "window-covering"
+	AccessoryTelevision     = "television"
+)
+
+var accessoryTypes = []string{
+	AccessorySwitch, AccessoryOutlet, AccessoryFan, AccessoryLightbulb, AccessoryValve, AccessoryAirPurifier,
+	AccessoryGarageDoor, AccessoryWindowCovering, AccessoryTelevision,
+}
+
+// Key returns what identifies the service's accessory: its ID, or its name when it has none.
+func (s Service) Key() string {
+	if s.ID != "" {
+		return s.ID
+	}
+
+	return s.Name
+}
+
+// SecretValues returns the values of the environment variables listed in Secrets, taken from Env or else from
+// hkswitch's environment, which the service inherits. Empty values are skipped.
+func (s Service) SecretValues() (list []string) {
+	for _, name := range s.Secrets {
+		value, ok := os.LookupEnv(name)
+
+		for _, kv := range s.Env {
+			if strings.HasPrefix(kv, name+"=") {
+				value, ok = strings.TrimPrefix(kv, name+"="), true
+			}
+		}
+
+		if ok && value != "" {
+			list = append(list, value)
+		}
+
+		for _, v := range s.Variants {
+			for _, kv := range v.Env {
+				if value := strings.TrimPrefix(kv, name+"="); value != kv && value != "" {
+					list = append(list, value)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// Sensor types.
+const (
+	SensorTemperature = "temperature"
+	SensorHumidity    = "humidity"
+	SensorLight       = "light"
+	SensorContact     = "contact"
+	SensorOccupancy   = "occupancy"
+	SensorMotion      = "motion"
+)
+
+var sensorTypes = []string{
+	SensorTemperature, SensorHumidity, SensorLight, SensorContact, SensorOccupancy, SensorMotion,
+}
+
+// Sensor is an accessory whose value is read from the output of a probe command, run every Interval. The output of
+// temperature, humidity and light sensors is a number, the one of contact, occupancy and motion sensors is true or
+// false (or yes/no, on/off, 1/0).
+type Sensor struct {
+	// ID identifies the sensor's accessory in HomeKit like Service.ID does.
+	ID string `yaml:"id"`
+
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Command []string `yaml:"command,flow"`
+	Workdir string   `yaml:"work-dir"`
+	Env     []string `yaml:"env"`
+
+	// Interval is the time between two runs of Command, 1 minute by default.
+	Interval time.Duration `yaml:"interval"`
+
+	// Timeout is how long Command can run before it's killed, and the probe failed, 10 seconds (or Interval, if
+	// shorter) by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Key returns what identifies the sensor's accessory: its ID, or its name when it has none.
+func (s Sensor) Key() string {
+	if s.ID != "" {
+		return s.ID
+	}
+
+	return s.Name
+}
+
+// ProbeInterval returns Interval, or its default when not set.
+func (s Sensor) ProbeInterval() time.Duration {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+
+	return time.Minute
+}
+
+// ProbeTimeout returns Timeout, or its default when not set.
+func (s Sensor) ProbeTimeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+
+	if interval := s.ProbeInterval(); interval < 10*time.Second {
+		return interval
+	}
+
+	return 10 * time.Second
+}
+
+// CustomAccessory is an accessory with the given services, whose characteristics are read and written by commands.
+type CustomAccessory struct {
+	// ID identifies the accessory in HomeKit like Service.ID does.
+	ID string `yaml:"id"`
+
+	Name     string          `yaml:"name"`
+	Services []CustomService `yaml:"services"`
+	Workdir  string          `yaml:"work-dir"`
+	Env      []string        `yaml:"env"`
+
+	// Interval is the time between two runs of the get commands, 1 minute by default.
+	Interval time.Duration `yaml:"interval"`
+
+	// Cache is how long a value read by a get command is used when HomeKit reads the characteristic, before the
+	// get command is run again, 10 seconds by default.
+	Cache time.Duration `yaml:"cache"`
+
+	// Timeout is how long a get or set command can run before it's killed, 10 seconds by default.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Key returns what identifies the accessory: its ID, or its name when it has none.
+func (a CustomAccessory) Key() string {
+	if a.ID != "" {
+		return a.ID
+	}
+
+	return a.Name
+}
+
+// PollInterval returns Interval, or its default when not set.
+func (a CustomAccessory) PollInterval() time.Duration {
+	if a.Interval > 0 {
+		return a.Interval
+	}
+
+	return time.Minute
+}
+
+// CacheDuration returns Cache, or its default when not set.
+func (a CustomAccessory) CacheDuration() time.Duration {
+	if a.Cache > 0 {
+		return a.Cache
+	}
+
+	return 10 * time.Second
+}
+
+// CommandTimeout returns Timeout, or its default when not set.
+func (a CustomAccessory) CommandTimeout() time.Duration {
+	if a.Timeout > 0 {
+		return a.Timeout
+	}
+
+	return 10 * time.Second
+}
+
+// CustomService is a HomeKit service, like thermostat or window-covering, of a CustomAccessory.
+type CustomService struct {
+	Type            string                 `yaml:"type"`
+	Characteristics []CustomCharacteristic `yaml:"characteristics"`
+}
+
+// CustomCharacteristic is a HomeKit characteristic, like current-temperature. Get is the command printing its value,
+// Set the command changing it, which receives the new value as its last argument and as HKSWITCH_VALUE in its
+// environment. Both are optional.
+type CustomCharacteristic struct {
+	Type string   `yaml:"type"`
+	Get  []string `yaml:"get,flow"`
+	Set  []string `yaml:"set,flow"`
+}
+
+// Group states, choosing when a group's switch is on.
+const (
+	GroupStateAll = "all"
+	GroupStateAny = "any"
+)
+
+// Group is a switch starting all of Services when turned on and stopping them when turned off. It's on when all of
+// them run, or any of them when State is GroupStateAny.
+type Group struct {
+	// ID identifies the group's accessory in HomeKit like Service.ID does.
+	ID string `yaml:"id"`
+
+	Name     string   `yaml:"name"`
+	Services []string `yaml:"services"`
+
+	// State is either GroupStateAll, the default, or GroupStateAny.
+	State string `yaml:"state"`
+}
+
+// Key returns what identifies the group's accessory: its ID, or its name when it has none.
+func (g Group) Key() string {
+	if g.ID != "" {
+		return g.ID
+	}
+
+	return g.Name
+}
+
+// Log drivers, choosing where a service's output goes.
+const (
+	LogDriverFile     = "file"
+	LogDriverJournald = "journald"
+	LogDriverSyslog   = "syslog"
+)
+
+// Log configures writing a service's output to a file, journald or syslog instead of hkswitch's stdout and stderr.
+// Address is the socket of journald or syslog, as unix:///path or udp://host:port, found automatically when empty.
+type Log struct {
+	Driver  string `yaml:"driver"`
+	Address string `yaml:"address"`
+
+	Path     string `yaml:"path"`
+	MaxSize  Size   `yaml:"max-size"`
+	MaxFiles int    `yaml:"max-files"`
+	Compress bool   `yaml:"compress"`
+	Merge    bool   `yaml:"merge"`
+}
+
+// StderrPath returns the path of the file for the service's stderr, which is Path when stdout and stderr are merged,
+// or Path with ".stderr" before its extension otherwise.
+func (l *Log) StderrPath() string {
+	if l.Merge {
+		return l.Path
+	}
+
+	ext := filepath.Ext(l.Path)
+
+	return strings.TrimSuffix(l.Path, ext) + ".stderr" + ext
+}
+
+// RedactPatterns returns the compiled Redact expressions.
+func (c *Config) RedactPatterns() []*regexp.Regexp {
+	list := make([]*regexp.Regexp, 0, len(c.Redact))
+	for _, expr := range c.Redact {
+		list = append(list, regexp.MustCompile(expr))
+	}
+
+	return list
+}
+
+// Size is a number of bytes, written in YAML as a plain number or with a K, M or G suffix (eg. 10M).
+type Size int64
+
+func (s *Size) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+
+	if str == "" {
+		return fmt.Errorf("empty size")
+	}
+
+	multiplier := int64(1)
+
+	switch strings.ToUpper(str[len(str)-1:]) {
+	case "K":
+		multiplier = 1 << 10
+	case "M":
+		multiplier = 1 << 20
+	case "G":
+		multiplier = 1 << 30
+	}
+
+	if multiplier != 1 {
+		str = str[:len(str)-1]
+	}
+
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", str)
+	}
+
+	*s = Size(n * multiplier)
+
+	return nil
+}
+
+var DefaultConfig = Config{HistoryLines: 200}
 
 func Load(f string) (Config, error) {
 	data, err := ioutil.ReadFile(f)
@@ -59,6 +561,23 @@ func Load(f string) (Config, error) {
 		return Config{}, fmt.Errorf("load file %s: %w", f, err)
 	}
 
+	// the bridges in the list get the same defaults as the single one
+	for i := range cfg.Bridges {
+		bridge := &cfg.Bridges[i]
+
+		if bridge.Manufacturer == "" {
+			bridge.Manufacturer = DefaultConfig.Manufacturer
+		}
+
+		if bridge.Model == "" {
+			bridge.Model = DefaultConfig.Model
+		}
+
+		if bridge.Firmware == "" {
+			bridge.Firmware = DefaultConfig.Firmware
+		}
+	}
+
 	if err := validate(cfg); err != nil {
 		return Config{}, err
 	}
@@ -71,15 +590,279 @@ func validate(cfg Config) error {
 		return fmt.Errorf("empty services list")
 	}
 
+	if cfg.LogFormat != "" && cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return fmt.Errorf("invalid log format %q", cfg.LogFormat)
+	}
+
+	if cfg.Color != "" && cfg.Color != "auto" && cfg.Color != "always" && cfg.Color != "never" {
+		return fmt.Errorf("invalid color %q", cfg.Color)
+	}
+
+	if cfg.HistoryLines < 0 {
+		return fmt.Errorf("invalid history lines %d: want >= 0", cfg.HistoryLines)
+	}
+
+	for _, expr := range cfg.Redact {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid redact expression: %w", err)
+		}
+	}
+
+	keys := make(map[string]bool)
+	// names as well as IDs, as groups, accessories and bridges refer to services by name
+	names := make(map[string]bool)
+
 	for i, svc := range cfg.Services {
 		if svc.Name == "" {
 			return fmt.Errorf("empty service name at %d", i)
 		}
 
-		if len(svc.Command) < 1 {
+		if names[svc.Name] {
+			return fmt.Errorf("duplicate service name %q", svc.Name)
+		}
+
+		names[svc.Name] = true
+
+		if keys[svc.Key()] {
+			return fmt.Errorf("duplicate service id %q", svc.Key())
+		}
+
+		keys[svc.Key()] = true
+
+		if svc.Accessory != "" && !contains(accessoryTypes, svc.Accessory) {
+			return fmt.Errorf("invalid accessory %q for service %s", svc.Accessory, svc.Name)
+		}
+
+		if len(svc.Command) < 1 && len(svc.Variants) == 0 {
 			return fmt.Errorf("empty command line for service %s", svc.Name)
 		}
+
+		if svc.Accessory == AccessoryTelevision && len(svc.Variants) == 0 {
+			return fmt.Errorf("empty variants list for television %s", svc.Name)
+		}
+
+		if svc.Accessory != AccessoryTelevision && len(svc.Variants) > 0 {
+			return fmt.Errorf("variants without television accessory for service %s", svc.Name)
+		}
+
+		if svc.Control != "" && svc.Control != ControlReadWrite && svc.Control != ControlReadOnly {
+			return fmt.Errorf("invalid control %q for service %s", svc.Control, svc.Name)
+		}
+
+		if svc.Invert && (contains([]string{AccessoryGarageDoor, AccessoryWindowCovering, AccessoryTelevision},
+			svc.Accessory) || svc.Replicas != nil) {
+			return fmt.Errorf("invert is not supported for service %s: its accessory shows more than on and off",
+				svc.Name)
+		}
+
+		if p := svc.Progress; p != nil {
+			if p.Show != "" && p.Show != ProgressBattery && p.Show != ProgressLightbulb {
+				return fmt.Errorf("invalid progress show %q for service %s", p.Show, svc.Name)
+			}
+
+			if p.Pattern != "" {
+				expr, err := regexp.Compile(p.Pattern)
+				if err != nil {
+					return fmt.Errorf("invalid progress pattern for service %s: %w", svc.Name, err)
+				}
+
+				if n := expr.NumSubexp(); n != 1 && n != 2 {
+					return fmt.Errorf("invalid progress pattern for service %s: want 1 or 2 groups, got %d",
+						svc.Name, n)
+				}
+			}
+		}
+
+		if r := svc.Replicas; r != nil {
+			if svc.Accessory != AccessoryLightbulb && svc.Accessory != AccessoryFan {
+				return fmt.Errorf("replicas without lightbulb or fan accessory for service %s", svc.Name)
+			}
+
+			if r.Max < 1 || r.Min < 0 || r.Min > r.Max {
+				return fmt.Errorf("invalid replicas for service %s: want 0 <= min <= max, 1 <= max", svc.Name)
+			}
+		}
+
+		variants := make(map[string]bool)
+
+		for i, v := range svc.Variants {
+			if v.Name == "" {
+				return fmt.Errorf("empty variant name at %d for service %s", i, svc.Name)
+			}
+
+			if variants[v.Name] {
+				return fmt.Errorf("duplicate variant %q for service %s", v.Name, svc.Name)
+			}
+
+			variants[v.Name] = true
+
+			if len(v.Command) < 1 {
+				return fmt.Errorf("empty command line for variant %s of service %s", v.Name, svc.Name)
+			}
+		}
+
+		if svc.Log != nil {
+			switch svc.Log.Driver {
+			case "", LogDriverFile:
+				if svc.Log.Path == "" {
+					return fmt.Errorf("empty log path for service %s", svc.Name)
+				}
+
+				if svc.Log.MaxFiles < 0 {
+					return fmt.Errorf("invalid max files %d for service %s: want >= 0", svc.Log.MaxFiles, svc.Name)
+				}
+			case LogDriverJournald, LogDriverSyslog:
+			default:
+				return fmt.Errorf("invalid log driver %q for service %s", svc.Log.Driver, svc.Name)
+			}
+		}
+	}
+
+	sensorKeys := make(map[string]bool)
+
+	for i, sensor := range cfg.Sensors {
+		if sensor.Name == "" {
+			return fmt.Errorf("empty sensor name at %d", i)
+		}
+
+		if sensorKeys[sensor.Key()] {
+			return fmt.Errorf("duplicate sensor id %q", sensor.Key())
+		}
+
+		sensorKeys[sensor.Key()] = true
+
+		if len(sensor.Command) < 1 {
+			return fmt.Errorf("empty command line for sensor %s", sensor.Name)
+		}
+
+		if !contains(sensorTypes, sensor.Type) {
+			return fmt.Errorf("invalid type %q for sensor %s", sensor.Type, sensor.Name)
+		}
+	}
+
+	accessoryKeys := make(map[string]bool)
+
+	for i, acc := range cfg.Accessories {
+		if acc.Name == "" {
+			return fmt.Errorf("empty accessory name at %d", i)
+		}
+
+		if accessoryKeys[acc.Key()] {
+			return fmt.Errorf("duplicate accessory id %q", acc.Key())
+		}
+
+		accessoryKeys[acc.Key()] = true
+
+		if len(acc.Services) == 0 {
+			return fmt.Errorf("empty services list for accessory %s", acc.Name)
+		}
+	}
+
+	services := make(map[string]Service)
+	for _, svc := range cfg.Services {
+		services[svc.Name] = svc
+	}
+
+	if err := validateBridges(cfg, services); err != nil {
+		return err
+	}
+
+	groupKeys := make(map[string]bool)
+
+	for i, group := range cfg.Groups {
+		if group.Name == "" {
+			return fmt.Errorf("empty group name at %d", i)
+		}
+
+		if groupKeys[group.Key()] {
+			return fmt.Errorf("duplicate group id %q", group.Key())
+		}
+
+		groupKeys[group.Key()] = true
+
+		if len(group.Services) == 0 {
+			return fmt.Errorf("empty services list for group %s", group.Name)
+		}
+
+		for _, name := range group.Services {
+			svc, ok := services[name]
+			if !ok {
+				return fmt.Errorf("unknown service %q in group %s", name, group.Name)
+			}
+
+			if svc.Control == ControlReadOnly {
+				return fmt.Errorf("read-only service %s in group %s", name, group.Name)
+			}
+		}
+
+		if group.State != "" && group.State != GroupStateAll && group.State != GroupStateAny {
+			return fmt.Errorf("invalid state %q for group %s", group.State, group.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateBridges checks that the bridges can run side by side, and that each service is shown by exactly one of
+// them.
+func validateBridges(cfg Config, services map[string]Service) error {
+	if len(cfg.Bridges) > 0 && cfg.Bridge.Name != "" {
+		return fmt.Errorf("both bridge and bridges set")
+	}
+
+	bridges := make(map[string]bool)
+	ports := make(map[string]bool)
+	bridgeOf := make(map[string]string)
+
+	for i, bridgeCfg := range cfg.BridgeConfigs() {
+		bridge := bridgeCfg.Bridge
+
+		if len(cfg.Bridges) > 0 && bridge.Name == "" {
+			return fmt.Errorf("empty bridge name at %d", i)
+		}
+
+		if bridges[bridge.StoragePath()] {
+			return fmt.Errorf("duplicate bridge storage dir %q", bridge.StoragePath())
+		}
+
+		bridges[bridge.StoragePath()] = true
+
+		if bridge.Port != "" {
+			if ports[bridge.Port] {
+				return fmt.Errorf("duplicate port %s for bridge %s", bridge.Port, bridge.Name)
+			}
+
+			ports[bridge.Port] = true
+		}
+
+		for _, name := range bridge.Services {
+			if _, ok := services[name]; !ok {
+				return fmt.Errorf("unknown service %q on bridge %s", name, bridge.Name)
+			}
+
+			if other, ok := bridgeOf[name]; ok {
+				return fmt.Errorf("service %s on both bridge %s and bridge %s", name, other, bridge.Name)
+			}
+
+			bridgeOf[name] = bridge.Name
+		}
+	}
+
+	for _, svc := range cfg.Services {
+		if _, ok := bridgeOf[svc.Name]; !ok {
+			return fmt.Errorf("service %s is not on any bridge", svc.Name)
+		}
 	}
 
 	return nil
 }
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
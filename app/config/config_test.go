@@ -0,0 +1,170 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		// want is part of the error, none if empty
+		want string
+	}{
+		{
+			name: "valid",
+			yaml: `
+bridge: {name: test}
+services:
+  - {name: a, command: [sleep, "1"]}
+  - {name: b, command: [sleep, "1"]}
+`,
+		},
+		{
+			name: "duplicate name",
+			yaml: `
+bridge: {name: test}
+services:
+  - {name: a, command: [sleep, "1"]}
+  - {name: a, id: other, command: [sleep, "1"]}
+`,
+			want: `duplicate service name "a"`,
+		},
+		{
+			name: "duplicate id",
+			yaml: `
+bridge: {name: test}
+services:
+  - {name: a, command: [sleep, "1"]}
+  - {name: b, id: a, command: [sleep, "1"]}
+`,
+			want: `duplicate service id "a"`,
+		},
+		{
+			name: "same name on two bridges",
+			yaml: `
+bridges:
+  - {name: one, services: [a]}
+  - {name: two, storage-dir: two, port: "12346", services: [a]}
+services:
+  - {name: a, command: [sleep, "1"]}
+  - {name: a, id: other, command: [sleep, "1"]}
+`,
+			want: `duplicate service name "a"`,
+		},
+		{
+			name: "no history",
+			yaml: `
+history-lines: 0
+bridge: {name: test}
+services:
+  - {name: a, command: [sleep, "1"]}
+`,
+		},
+		{
+			name: "negative history",
+			yaml: `
+history-lines: -1
+bridge: {name: test}
+services:
+  - {name: a, command: [sleep, "1"]}
+`,
+			want: "invalid history lines -1",
+		},
+		{
+			name: "negative max files",
+			yaml: `
+bridge: {name: test}
+services:
+  - {name: a, command: [sleep, "1"], log: {path: a.log, max-files: -1}}
+`,
+			want: "invalid max files -1 for service a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(path, []byte(tt.yaml), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Load(path)
+
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("is = %v, want = %v", err, nil)
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("is = %v, want = %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicas_Count(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas Replicas
+		level    float64
+		want     int
+	}{
+		{name: "off", replicas: Replicas{Max: 4}, level: 0, want: 0},
+		{name: "lowest by default", replicas: Replicas{Max: 4}, level: 1, want: 1},
+		{name: "min", replicas: Replicas{Min: 2, Max: 4}, level: 10, want: 2},
+		{name: "rounded up", replicas: Replicas{Max: 4}, level: 26, want: 2},
+		{name: "exact", replicas: Replicas{Max: 4}, level: 50, want: 2},
+		{name: "max", replicas: Replicas{Max: 4}, level: 100, want: 4},
+		{name: "over max", replicas: Replicas{Max: 4}, level: 150, want: 4},
+		{name: "single", replicas: Replicas{Max: 1}, level: 30, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if is, want := tt.replicas.Count(tt.level), tt.want; is != want {
+				t.Fatalf("is = %v, want = %v", is, want)
+			}
+		})
+	}
+}
+
+func TestReplicas_Level(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas Replicas
+		n        int
+		want     float64
+	}{
+		{name: "none", replicas: Replicas{Max: 4}, n: 0, want: 0},
+		{name: "one", replicas: Replicas{Max: 4}, n: 1, want: 25},
+		{name: "max", replicas: Replicas{Max: 4}, n: 4, want: 100},
+		{name: "over max", replicas: Replicas{Max: 4}, n: 5, want: 100},
+		{name: "thirds", replicas: Replicas{Max: 3}, n: 2, want: 200.0 / 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if is, want := tt.replicas.Level(tt.n), tt.want; is != want {
+				t.Fatalf("is = %v, want = %v", is, want)
+			}
+		})
+	}
+}
+
+func TestReplicas_CountOfLevel(t *testing.T) {
+	// the level shown for n instances gives n instances back, so that HomeKit showing it doesn't scale the service
+	replicas := Replicas{Min: 1, Max: 7}
+
+	for n := 1; n <= replicas.Max; n++ {
+		if is, want := replicas.Count(replicas.Level(n)), n; is != want {
+			t.Fatalf("is = %v, want = %v", is, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+	"github.com/brutella/hc/log"
+	"io"
+	"mrz.io/hkswitch/app/output"
+	"mrz.io/hkswitch/service"
+	"strings"
+)
+
+// failure is the event reported when a service stops with an error or fails to start.
+type failure struct {
+	Service string
+	Err     error
+
+	// Output holds the last lines of output of the service.
+	Output []output.Line
+}
+
+func (f failure) String() string {
+	b := &strings.Builder{}
+	_, _ = fmt.Fprintf(b, "%s failed: %s", f.Service, f.Err)
+
+	if len(f.Output) > 0 {
+		_, _ = fmt.Fprintf(b, ", last %d lines of output:", len(f.Output))
+	}
+
+	for _, line := range f.Output {
+		_, _ = fmt.Fprintf(b, "\n  %s %s: %s", line.Time.Format("15:04:05.000"), line.Stream, line.Text)
+	}
+
+	return b.String()
+}
+
+// reportFailures logs a failure, including the recent output from history, for every Change with an error read
+// from the subscription channel, except for services that were asked to stop.
+func reportFailures(subscription <-chan service.Change, history *output.History) {
+	go func() {
+		for change := range subscription {
+			if change.Err == nil || change.Requested {
+				continue
+			}
+
+			f := failure{
+				Service: change.Service.Name(),
+				Err:     change.Err,
+				Output:  history.Lines(change.Service.Name()),
+			}
+
+			log.Info.Println(f)
+		}
+	}()
+}
+
+// reportEvents writes a Record to w for every Change read from the subscription channel. The Records for failures
+// include the recent output from history, masked by redactor like the error, and failures of one of the instances of
+// a service are reported as such even if the service keeps running.
+func reportEvents(subscription <-chan service.Change, history *output.History, redactor *output.Redactor,
+	w io.Writer) {
+	go func() {
+		for change := range subscription {
+			r := output.Record{
+				Time:    change.Timestamp,
+				Service: change.Service.Name(),
+				Stream:  output.StreamEvent,
+			}
+
+			switch {
+			case change.Err != nil && !change.Requested:
+				r.Event = "failed"
+				r.Error = redactor.RedactString(change.Err.Error())
+				r.Output = redactor.RedactLines(history.Lines(change.Service.Name()))
+			case change.Scaled:
+				r.Event = "scaled"
+			case change.Running:
+				r.Event = "started"
+			default:
+				r.Event = "stopped"
+			}
+
+			r.Message = fmt.Sprintf("%s %s", r.Service, r.Event)
+
+			if change.Scaled {
+				r.Message = fmt.Sprintf("%s to %d instances", r.Message, change.Instances)
+			}
+
+			_ = output.WriteRecord(w, r)
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/app/output"
+	"mrz.io/hkswitch/service"
+	"reflect"
+	"testing"
+)
+
+func TestReportEvents_Redacted(t *testing.T) {
+	history := output.NewHistory(10)
+	_, _ = history.Stderr(config.Service{Name: "db"}).Write([]byte("connecting with s3cr3t\n"))
+
+	redactor := output.NewRedactor([]string{"s3cr3t"}, nil)
+
+	subscription := make(chan service.Change, 1)
+	defer close(subscription)
+
+	r, w := io.Pipe()
+	reportEvents(subscription, history, redactor, w)
+
+	svc := service.NewDaemon("db", &service.Command{Path: "true"}, ioutil.Discard, ioutil.Discard)
+	subscription <- service.Change{Service: svc, Err: errors.New("login failed for s3cr3t")}
+
+	var record output.Record
+	if err := json.NewDecoder(r).Decode(&record); err != nil {
+		t.Fatal(err)
+	}
+
+	if is, want := record.Error, "login failed for ***"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	var texts []string
+	for _, line := range record.Output {
+		texts = append(texts, line.Text)
+	}
+
+	if is, want := texts, []string{"connecting with ***"}; !reflect.DeepEqual(is, want) {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
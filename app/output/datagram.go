@@ -0,0 +1,132 @@
+package output
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+)
+
+// datagramConn is a connected datagram socket that is dialed again when a write fails, e.g. because the daemon
+// listening on it was restarted. Each Write is sent as one datagram.
+type datagramConn struct {
+	network string
+	address string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func dialDatagram(network, address string) (*datagramConn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &datagramConn{network: network, address: address, conn: conn}, nil
+}
+
+func (d *datagramConn) Write(data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n, err := d.conn.Write(data)
+	if err == nil {
+		return n, nil
+	}
+
+	conn, dialErr := net.Dial(d.network, d.address)
+	if dialErr != nil {
+		return n, err
+	}
+
+	_ = d.conn.Close()
+	d.conn = conn
+
+	return d.conn.Write(data)
+}
+
+func (d *datagramConn) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.conn.Close()
+}
+
+// parseDatagramAddress parses an address like unix:///dev/log or udp://localhost:514 into the network and address
+// to dial.
+func parseDatagramAddress(s string) (network, address string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "unix", "unixgram":
+		return "unixgram", u.Path, nil
+	case "udp", "udp4", "udp6":
+		return u.Scheme, u.Host, nil
+	default:
+		return "", "", fmt.Errorf("unsupported address %q", s)
+	}
+}
+
+// datagramConns opens a datagramConn per address and keeps track of them, for the StreamsFactories writing to
+// sockets.
+type datagramConns struct {
+	mu    sync.Mutex
+	conns map[string]*datagramConn
+	err   error
+}
+
+// get returns the datagramConn for address, dialing it the first time, or nil if dialing fails.
+func (d *datagramConns) get(address string) *datagramConn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if conn, ok := d.conns[address]; ok {
+		return conn
+	}
+
+	network, addr, err := parseDatagramAddress(address)
+	if err == nil {
+		var conn *datagramConn
+		if conn, err = dialDatagram(network, addr); err == nil {
+			if d.conns == nil {
+				d.conns = make(map[string]*datagramConn)
+			}
+
+			d.conns[address] = conn
+			return conn
+		}
+	}
+
+	if d.err == nil {
+		d.err = fmt.Errorf("%s: %w", address, err)
+	}
+
+	return nil
+}
+
+// Err returns the first error encountered dialing an address.
+func (d *datagramConns) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.err
+}
+
+// Close closes all connections, returning the first error.
+func (d *datagramConns) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var firstErr error
+	for _, conn := range d.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,137 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"mrz.io/hkswitch/app/config"
+	"sync"
+	"time"
+)
+
+// maxHistoryLineLen is the length after which a line with no newline yet is recorded anyway.
+const maxHistoryLineLen = 4096
+
+// Line is a line of output recorded by History.
+type Line struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"message"`
+}
+
+// History is a StreamsFactory whose writers record the most recent lines written by each service, to be queried
+// with Lines. Lines are recorded when complete, so a line split across writes is recorded once.
+type History struct {
+	size int
+
+	mu    sync.Mutex
+	rings map[string]*ring
+}
+
+// NewHistory creates a History keeping the last size lines of each service.
+func NewHistory(size int) *History {
+	return &History{size: size, rings: make(map[string]*ring)}
+}
+
+func (h *History) Stdout(svc config.Service) io.Writer {
+	return &historyWriter{h: h, name: svc.Name, stream: "stdout"}
+}
+
+func (h *History) Stderr(svc config.Service) io.Writer {
+	return &historyWriter{h: h, name: svc.Name, stream: "stderr"}
+}
+
+// Lines returns the recorded lines of the named service, oldest first.
+func (h *History) Lines(name string) []Line {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rings[name]
+	if !ok {
+		return nil
+	}
+
+	return r.lines()
+}
+
+func (h *History) record(name string, line Line) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rings[name]
+	if !ok {
+		r = &ring{buf: make([]Line, h.size)}
+		h.rings[name] = r
+	}
+
+	r.add(line)
+}
+
+// ring is a fixed size circular buffer of lines.
+type ring struct {
+	buf  []Line
+	next int
+	full bool
+}
+
+func (r *ring) add(line Line) {
+	if len(r.buf) == 0 {
+		return
+	}
+
+	r.buf[r.next] = line
+	r.next = (r.next + 1) % len(r.buf)
+
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *ring) lines() []Line {
+	if !r.full {
+		return append([]Line(nil), r.buf[:r.next]...)
+	}
+
+	return append(append([]Line(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// historyWriter records the lines written to one stream of a service.
+type historyWriter struct {
+	h      *History
+	name   string
+	stream string
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *historyWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(data)
+	now := time.Now()
+
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, data...)
+
+			if len(w.partial) >= maxHistoryLineLen {
+				w.flush(now)
+			}
+
+			break
+		}
+
+		w.partial = append(w.partial, data[:i]...)
+		w.flush(now)
+		data = data[i+1:]
+	}
+
+	return n, nil
+}
+
+func (w *historyWriter) flush(t time.Time) {
+	w.h.record(w.name, Line{Time: t, Stream: w.stream, Text: string(w.partial)})
+	w.partial = w.partial[:0]
+}
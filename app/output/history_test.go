@@ -0,0 +1,39 @@
+package output
+
+import (
+	"mrz.io/hkswitch/app/config"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(3)
+	svc := config.Service{Name: "svc"}
+
+	stdout := h.Stdout(svc)
+	stderr := h.Stderr(svc)
+
+	_, _ = stdout.Write([]byte("one\ntw"))
+	_, _ = stderr.Write([]byte("three\n"))
+	_, _ = stdout.Write([]byte("o\nfour\n"))
+
+	want := []Line{
+		{Stream: "stderr", Text: "three"},
+		{Stream: "stdout", Text: "two"},
+		{Stream: "stdout", Text: "four"},
+	}
+
+	is := h.Lines("svc")
+	if len(is) != len(want) {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	for i := range want {
+		if is[i].Stream != want[i].Stream || is[i].Text != want[i].Text {
+			t.Fatalf("is = %v, want = %v", is, want)
+		}
+	}
+
+	if is := h.Lines("other"); is != nil {
+		t.Fatalf("is = %v, want = nil", is)
+	}
+}
@@ -0,0 +1,78 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mrz.io/hkswitch/app/config"
+	"strconv"
+)
+
+// DefaultJournaldAddress is the address of journald's native protocol socket.
+const DefaultJournaldAddress = "unix:///run/systemd/journal/socket"
+
+const (
+	priorityErr  = 3
+	priorityInfo = 6
+)
+
+// Journald is a StreamsFactory sending each line of output to journald, using the native protocol, as a message with
+// the service's name as SYSLOG_IDENTIFIER, and priority info for stdout and err for stderr.
+type Journald struct {
+	datagramConns
+}
+
+func NewJournald() *Journald {
+	return &Journald{}
+}
+
+func (j *Journald) Stdout(svc config.Service) io.Writer {
+	return j.writer(svc, priorityInfo)
+}
+
+func (j *Journald) Stderr(svc config.Service) io.Writer {
+	return j.writer(svc, priorityErr)
+}
+
+func (j *Journald) writer(svc config.Service, priority int) io.Writer {
+	address := svc.Log.Address
+	if address == "" {
+		address = DefaultJournaldAddress
+	}
+
+	conn := j.get(address)
+	if conn == nil {
+		return io.Discard
+	}
+
+	return newLineWriter(conn, journaldFormatter(svc.Name, priority))
+}
+
+func journaldFormatter(identifier string, priority int) formatter {
+	return func(dst []byte, line []byte) []byte {
+		dst = appendJournaldField(dst, "PRIORITY", []byte(strconv.Itoa(priority)))
+		dst = appendJournaldField(dst, "SYSLOG_IDENTIFIER", []byte(identifier))
+		return appendJournaldField(dst, "MESSAGE", line)
+	}
+}
+
+// appendJournaldField appends a field in the native protocol's format: KEY=value and a newline, or, when the value
+// contains a newline, KEY, a newline, the value's length as a little-endian 64-bit integer, the value and a newline.
+func appendJournaldField(dst []byte, key string, value []byte) []byte {
+	dst = append(dst, key...)
+
+	if bytes.IndexByte(value, '\n') < 0 {
+		dst = append(dst, '=')
+		dst = append(dst, value...)
+		return append(dst, '\n')
+	}
+
+	dst = append(dst, '\n')
+
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	dst = append(dst, size[:]...)
+	dst = append(dst, value...)
+
+	return append(dst, '\n')
+}
@@ -0,0 +1,87 @@
+package output
+
+import (
+	"fmt"
+	"mrz.io/hkswitch/app/config"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func listenUnixgram(t *testing.T) (net.PacketConn, string) {
+	path := filepath.Join(t.TempDir(), "socket")
+
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn, "unix://" + path
+}
+
+func readDatagram(t *testing.T, conn net.PacketConn) string {
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(buf[:n])
+}
+
+func TestJournald(t *testing.T) {
+	conn, address := listenUnixgram(t)
+
+	j := NewJournald()
+	defer j.Close()
+
+	svc := config.Service{Name: "test", Log: &config.Log{Driver: config.LogDriverJournald, Address: address}}
+
+	stdout := j.Stdout(svc)
+	stderr := j.Stderr(svc)
+
+	if err := j.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = stdout.Write([]byte("hello\nwor"))
+	_, _ = stdout.Write([]byte("ld\n"))
+	_, _ = stderr.Write([]byte("oops\n"))
+
+	want := []string{
+		"PRIORITY=6\nSYSLOG_IDENTIFIER=test\nMESSAGE=hello\n",
+		"PRIORITY=6\nSYSLOG_IDENTIFIER=test\nMESSAGE=world\n",
+		"PRIORITY=3\nSYSLOG_IDENTIFIER=test\nMESSAGE=oops\n",
+	}
+
+	for _, w := range want {
+		if is := readDatagram(t, conn); is != w {
+			t.Fatalf("is = %q, want = %q", is, w)
+		}
+	}
+}
+
+func TestJournald_NoSocket(t *testing.T) {
+	j := NewJournald()
+
+	address := "unix://" + filepath.Join(t.TempDir(), "missing")
+	j.Stdout(config.Service{Name: "test", Log: &config.Log{Address: address}})
+
+	if is := j.Err(); is == nil {
+		t.Fatalf("is = %v, want an error", is)
+	}
+}
+
+func TestAppendJournaldField(t *testing.T) {
+	is := string(appendJournaldField(nil, "MESSAGE", []byte("a\nb")))
+	want := fmt.Sprintf("MESSAGE\n%s%s", "\x03\x00\x00\x00\x00\x00\x00\x00", "a\nb\n")
+
+	if is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
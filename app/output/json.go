@@ -0,0 +1,72 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"mrz.io/hkswitch/app/config"
+	"time"
+)
+
+// StreamEvent is the stream of the Records reporting service lifecycle events.
+const StreamEvent = "event"
+
+// Record is the JSON object written for each line of output, and for each service lifecycle event.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Stream  string    `json:"stream"`
+	Message string    `json:"message"`
+
+	// Event, Error and Output are only set for lifecycle events.
+	Event  string `json:"event,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Output []Line `json:"output,omitempty"`
+}
+
+// WriteRecord writes r as a line of JSON to w.
+func WriteRecord(w io.Writer, r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+
+	return err
+}
+
+// WithJSON returns a LineWriter writing each line to w as a JSON Record.
+func WithJSON(w io.Writer, service, stream string) *LineWriter {
+	return newLineWriter(NewSink(w), jsonFormatter(service, stream))
+}
+
+func jsonFormatter(service, stream string) formatter {
+	return func(dst []byte, line []byte) []byte {
+		data, err := json.Marshal(Record{Time: time.Now(), Service: service, Stream: stream, Message: string(line)})
+		if err != nil {
+			return dst
+		}
+
+		dst = append(dst, data...)
+		return append(dst, '\n')
+	}
+}
+
+// JSON is a StreamsFactory writing the output of the services as JSON Records.
+type JSON struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// NewJSON creates a JSON writing to stdout and stderr, which may be shared as with NewPrefixer.
+func NewJSON(stdout, stderr io.Writer) *JSON {
+	return &JSON{stdout: NewSink(stdout), stderr: NewSink(stderr)}
+}
+
+func (j *JSON) Stdout(svc config.Service) io.Writer {
+	return WithJSON(j.stdout, svc.Name, "stdout")
+}
+
+func (j *JSON) Stderr(svc config.Service) io.Writer {
+	return WithJSON(j.stderr, svc.Name, "stderr")
+}
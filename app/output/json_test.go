@@ -0,0 +1,34 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWithJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	jw := WithJSON(buf, "svc", "stderr")
+
+	_, _ = jw.Write([]byte("hello \"world\"\nbye\n"))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if is, want := len(lines), 2; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	var r Record
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Service != "svc" || r.Stream != "stderr" || r.Message != "hello \"world\"" || r.Time.IsZero() {
+		t.Fatalf("is = %+v, want service svc, stream stderr, message %q and a time", r, "hello \"world\"")
+	}
+
+	if r.Event != "" || r.Error != "" || r.Output != nil {
+		t.Fatalf("is = %+v, want no event fields", r)
+	}
+}
@@ -0,0 +1,186 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// partialLineTimeout is how long a line with no newline yet is buffered before being written anyway.
+	partialLineTimeout = 500 * time.Millisecond
+
+	// maxLineLen is the length after which a line is split, the rest being written as a new line.
+	maxLineLen = 64 << 10
+)
+
+// Sink serializes the writes to an io.Writer shared by several writers, e.g. hkswitch's stdout, so that each Write
+// is written in whole before another one starts.
+type Sink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewSink returns a Sink writing to w, or w itself if it's already a *Sink.
+func NewSink(w io.Writer) *Sink {
+	if s, ok := w.(*Sink); ok {
+		return s
+	}
+
+	return &Sink{w: w}
+}
+
+func (s *Sink) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(data)
+}
+
+// formatter appends a formatted line, including the trailing newline, to dst. line does not contain the newline.
+type formatter func(dst []byte, line []byte) []byte
+
+// LineWriter is an io.Writer buffering what is written until a newline, and then writing the formatted line to its
+// sink with a single Write. A line with no newline is written after a timeout, or when it grows too long, and what
+// follows is written as a new line, but for the newline ending a line written after the timeout, which is dropped
+// instead of making an empty line. A "\r" not followed by "\n", like the ones used to redraw progress bars,
+// discards what was buffered for the line so far, so that only the latest version of the line is written.
+type LineWriter struct {
+	sink   io.Writer
+	format formatter
+
+	mu      sync.Mutex
+	buf     []byte
+	out     []byte
+	cr      bool
+	timer   *time.Timer
+	pending bool
+	// timedOut is set when the partial line was written after the timeout, until more of the output is written.
+	timedOut bool
+}
+
+func newLineWriter(sink io.Writer, format formatter) *LineWriter {
+	return &LineWriter{sink: sink, format: format}
+}
+
+func (w *LineWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(data)
+
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			w.buffer(data)
+			break
+		}
+
+		w.buffer(data[:i])
+
+		switch data[i] {
+		case '\n':
+			w.cr = false
+
+			// the end of the line written after the timeout, already written with it
+			if w.timedOut && len(w.buf) == 0 {
+				w.timedOut = false
+				break
+			}
+
+			w.timedOut = false
+			if err := w.writeLine(); err != nil {
+				return n - len(data), err
+			}
+		case '\r':
+			w.cr = true
+		}
+
+		data = data[i+1:]
+	}
+
+	if len(w.buf) > 0 || w.cr {
+		w.schedule()
+	}
+
+	return n, nil
+}
+
+// Flush writes the buffered partial line, if any.
+func (w *LineWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.flush()
+}
+
+func (w *LineWriter) flush() error {
+	w.cr = false
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	return w.writeLine()
+}
+
+// buffer appends data to the current line, discarding the line first if it was ended by "\r" alone.
+func (w *LineWriter) buffer(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	w.timedOut = false
+
+	if w.cr {
+		w.cr = false
+		w.buf = w.buf[:0]
+	}
+
+	for len(w.buf)+len(data) > maxLineLen {
+		n := maxLineLen - len(w.buf)
+		w.buf = append(w.buf, data[:n]...)
+		_ = w.writeLine()
+		data = data[n:]
+	}
+
+	w.buf = append(w.buf, data...)
+}
+
+func (w *LineWriter) writeLine() error {
+	w.out = w.format(w.out[:0], w.buf)
+	w.buf = w.buf[:0]
+
+	_, err := w.sink.Write(w.out)
+
+	return err
+}
+
+// schedule arranges for the partial line to be flushed after partialLineTimeout, unless already scheduled.
+func (w *LineWriter) schedule() {
+	if w.pending {
+		return
+	}
+
+	w.pending = true
+
+	if w.timer == nil {
+		w.timer = time.AfterFunc(partialLineTimeout, w.onTimeout)
+	} else {
+		w.timer.Reset(partialLineTimeout)
+	}
+}
+
+func (w *LineWriter) onTimeout() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = false
+
+	if len(w.buf) > 0 {
+		w.timedOut = true
+	}
+
+	_ = w.flush()
+}
@@ -0,0 +1,431 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mrz.io/hkswitch/app/config"
+	"os"
+	"sync"
+)
+
+const (
+	defaultMaxSize  = 10 << 20
+	defaultMaxFiles = 5
+
+	// defaultMaxPending is how much data a RotatingFile queues before dropping what's written.
+	defaultMaxPending = 4 << 20
+)
+
+// RotatingFile is an io.Writer appending to a file, which is rotated when it grows past a max size. Writes never
+// block: data is queued in memory and written to the file by a background goroutine, so a slow disk delays the
+// writes instead of the program writing. When the queue is full, whole lines are dropped, and a line saying how many
+// bytes were lost is written once the queue is drained. Rotation happens only at the start of a line, so lines are
+// never split across files.
+type RotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	compress bool
+
+	mu         sync.Mutex
+	pending    *bytes.Buffer
+	maxPending int
+	dropped    int
+	// dropping is set while the rest of a line that was partly dropped is written, to drop it too.
+	dropping bool
+	closed   bool
+	err      error
+
+	wake chan struct{}
+	done chan struct{}
+
+	// writing is held while queued data is written, by the background goroutine or Flush, and guards the fields
+	// below.
+	writing     sync.Mutex
+	f           *os.File
+	size        int64
+	atLineStart bool
+	compressing sync.WaitGroup
+}
+
+// NewRotatingFile opens the file at path for appending. When the file grows past maxSize bytes it's renamed to
+// path.1 (and path.1 to path.2, and so on) keeping at most maxFiles old files, which are gzipped when compress is set.
+func NewRotatingFile(path string, maxSize int64, maxFiles int, compress bool) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:        path,
+		maxSize:     maxSize,
+		maxFiles:    maxFiles,
+		compress:    compress,
+		pending:     &bytes.Buffer{},
+		maxPending:  defaultMaxPending,
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		atLineStart: true,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	go r.run()
+
+	return r, nil
+}
+
+// Write queues data to be written to the file.
+func (r *RotatingFile) Write(data []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, fmt.Errorf("%s: closed", r.path)
+	}
+
+	r.queue(data)
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+
+	return len(data), nil
+}
+
+// queue adds data to the queue, dropping the lines that don't fit whole, as what is written is not always made of
+// whole lines, e.g. chunks read from a pipe.
+func (r *RotatingFile) queue(data []byte) {
+	if r.dropping {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			r.dropped += len(data)
+			return
+		}
+
+		r.dropped += i + 1
+		r.dropping = false
+		data = data[i+1:]
+	}
+
+	if room := r.maxPending - r.pending.Len(); len(data) > room {
+		// up to the last line that fits
+		accepted := data[:bytes.LastIndexByte(data[:room], '\n')+1]
+		rest := data[len(accepted):]
+
+		r.pending.Write(accepted)
+		r.dropped += len(rest)
+		r.dropping = rest[len(rest)-1] != '\n'
+
+		return
+	}
+
+	r.pending.Write(data)
+}
+
+// Close writes any queued data and closes the file, returning the first error encountered writing or rotating it.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+
+	r.closed = true
+	close(r.wake)
+	r.mu.Unlock()
+
+	<-r.done
+	r.compressing.Wait()
+
+	if err := r.f.Close(); err != nil {
+		r.setErr(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Flush writes the queued data to the file, blocking until it's written.
+func (r *RotatingFile) Flush() error {
+	r.writePending()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+func (r *RotatingFile) run() {
+	defer close(r.done)
+
+	for range r.wake {
+		r.writePending()
+	}
+
+	// the last writes might have happened after the last wake up
+	r.writePending()
+}
+
+// writePending writes the data queued so far.
+func (r *RotatingFile) writePending() {
+	r.writing.Lock()
+	defer r.writing.Unlock()
+
+	r.mu.Lock()
+	chunk, dropped := r.pending, r.dropped
+	r.pending, r.dropped = &bytes.Buffer{}, 0
+	r.mu.Unlock()
+
+	r.write(chunk.Bytes())
+
+	if dropped > 0 {
+		note := fmt.Sprintf("hkswitch: dropped %d bytes of output, the file couldn't be written fast enough\n",
+			dropped)
+		if !r.atLineStart {
+			note = "\n" + note
+		}
+
+		r.write([]byte(note))
+	}
+}
+
+// write writes data to the file line by line, rotating it before a line that would make it grow past maxSize.
+func (r *RotatingFile) write(data []byte) {
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+
+		data = data[len(line):]
+
+		if r.atLineStart && r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+			if err := r.rotate(); err != nil {
+				r.setErr(err)
+			}
+		}
+
+		n, err := r.f.Write(line)
+		if err != nil {
+			r.setErr(err)
+		}
+
+		r.size += int64(n)
+		r.atLineStart = line[len(line)-1] == '\n'
+	}
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = info.Size()
+
+	return nil
+}
+
+// rotate closes the file, shifts the old files by one and opens a new file.
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	// the previous path.1 must be compressed before moving it
+	r.compressing.Wait()
+
+	// drop the oldest file, then shift the others by one
+	for _, ext := range []string{"", ".gz"} {
+		if err := os.Remove(r.rotatedPath(r.maxFiles) + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for i := r.maxFiles - 1; i >= 0; i-- {
+		for _, ext := range []string{"", ".gz"} {
+			if err := os.Rename(r.rotatedPath(i)+ext, r.rotatedPath(i+1)+ext); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if r.compress && r.maxFiles > 0 {
+		r.compressing.Add(1)
+
+		go func() {
+			defer r.compressing.Done()
+
+			if err := gzipFile(r.rotatedPath(1)); err != nil {
+				r.setErr(err)
+			}
+		}()
+	}
+
+	return r.open()
+}
+
+// rotatedPath returns the path of the nth old file, the current file being the 0th.
+func (r *RotatingFile) rotatedPath(n int) string {
+	if n == 0 {
+		return r.path
+	}
+
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
+
+func (r *RotatingFile) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// gzipFile compresses the file at path into path.gz, removing the original.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = src.Close()
+	}()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+
+	if _, err := io.Copy(zw, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// LogFiles is a StreamsFactory writing the output of each service to the files set in its log configuration.
+type LogFiles struct {
+	mu    sync.Mutex
+	files map[string]*RotatingFile
+	err   error
+}
+
+func NewLogFiles() *LogFiles {
+	return &LogFiles{files: make(map[string]*RotatingFile)}
+}
+
+func (l *LogFiles) Stdout(svc config.Service) io.Writer {
+	return l.stream(svc.Log, svc.Log.Path)
+}
+
+func (l *LogFiles) Stderr(svc config.Service) io.Writer {
+	return l.stream(svc.Log, svc.Log.StderrPath())
+}
+
+// stream returns the writer of one of the service's streams to the file at path. When stdout and stderr are merged,
+// each stream writes whole lines to the file, so that the partial lines of the two are not mixed.
+func (l *LogFiles) stream(cfg *config.Log, path string) io.Writer {
+	f := l.open(cfg, path)
+	if !cfg.Merge {
+		return f
+	}
+
+	return newLineWriter(f, prefixFormatter("", ""))
+}
+
+// Err returns the first error encountered opening a file. The writers for files that can't be opened discard
+// everything.
+func (l *LogFiles) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.err
+}
+
+// Flush writes the data queued for all files, returning the first error.
+func (l *LogFiles) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for _, f := range l.files {
+		if err := f.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Close closes all files, returning the first error.
+func (l *LogFiles) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var firstErr error
+	for _, f := range l.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// open returns the RotatingFile for path, opening it the first time, so that merged streams share the same file.
+func (l *LogFiles) open(cfg *config.Log, path string) io.Writer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f, ok := l.files[path]; ok {
+		return f
+	}
+
+	maxSize := int64(cfg.MaxSize)
+	if maxSize == 0 {
+		maxSize = defaultMaxSize
+	}
+
+	maxFiles := cfg.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = defaultMaxFiles
+	}
+
+	f, err := NewRotatingFile(path, maxSize, maxFiles, cfg.Compress)
+	if err != nil {
+		if l.err == nil {
+			l.err = err
+		}
+
+		return io.Discard
+	}
+
+	l.files[path] = f
+
+	return f
+}
@@ -0,0 +1,286 @@
+package output
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"mrz.io/hkswitch/app/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	f, err := NewRotatingFile(path, 20, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lines are 8 bytes long, so two lines fit in each file and the first two lines are dropped
+	for i := 0; i < 7; i++ {
+		_, _ = f.Write([]byte(fmt.Sprintf("line %d\n", i)))
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		path:        "line 6\n",
+		path + ".1": "line 4\nline 5\n",
+		path + ".2": "line 2\nline 3\n",
+	}
+
+	for p, w := range want {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if is := string(data); is != w {
+			t.Fatalf("%s: is = %q, want = %q", p, is, w)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("is = %v, want not exist error", err)
+	}
+}
+
+func TestRotatingFile_SplitLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	f, err := NewRotatingFile(path, 10, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = f.Write([]byte("first "))
+	_, _ = f.Write([]byte("line\nsecond "))
+	_, _ = f.Write([]byte("line\n"))
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, w := range map[string]string{path: "second line\n", path + ".1": "first line\n"} {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if is := string(data); is != w {
+			t.Fatalf("%s: is = %q, want = %q", p, is, w)
+		}
+	}
+}
+
+func TestRotatingFile_Compress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	f, err := NewRotatingFile(path, 5, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = f.Write([]byte("hello\nworld\n"))
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := os.Open(path + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = gz.Close()
+	}()
+
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if is, want := string(data), "hello\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Fatalf("is = %v, want not exist error", err)
+	}
+}
+
+func TestRotatingFile_ConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	f, err := NewRotatingFile(path, 1<<20, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				_, _ = f.Write([]byte("line\n"))
+			}
+			done <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if is, want := strings.Count(string(data), "line\n"), 400; is != want {
+		t.Fatalf("is = %d, want = %d", is, want)
+	}
+}
+
+func TestRotatingFile_MaxPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+
+	f, err := NewRotatingFile(path, 1<<20, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.maxPending = 16
+
+	// a stalled disk: nothing queued is written until it's released
+	f.writing.Lock()
+
+	// lines are 7 bytes long, so two lines fit in the queue and the next two are dropped
+	for i := 0; i < 4; i++ {
+		_, _ = f.Write([]byte(fmt.Sprintf("line %d\n", i)))
+	}
+
+	f.writing.Unlock()
+
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = f.Write([]byte("line 4\n"))
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "line 0\nline 1\nhkswitch: dropped 14 bytes of output, the file couldn't be written fast enough\nline 4\n"
+	if is := string(data); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestRotatingFile_MaxPending_Lines(t *testing.T) {
+	note := "hkswitch: dropped %d bytes of output, the file couldn't be written fast enough\n"
+
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name: "chunks",
+			// as read from a pipe: lines are cut across writes
+			writes: []string{"line 0\nli", "ne 1\nline 2\nli", "ne 3\n"},
+			want:   "line 0\nline 1\n" + fmt.Sprintf(note, 14) + "line 4\n",
+		},
+		{
+			name:   "partial line",
+			writes: []string{"line 0\nhalf", " of a long line\n"},
+			want:   "line 0\nhalf\n" + fmt.Sprintf(note, 16) + "line 4\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.log")
+
+			f, err := NewRotatingFile(path, 1<<20, 1, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			f.maxPending = 16
+
+			f.writing.Lock()
+
+			for _, w := range tt.writes {
+				_, _ = f.Write([]byte(w))
+			}
+
+			f.writing.Unlock()
+
+			if err := f.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, _ = f.Write([]byte("line 4\n"))
+
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if is, want := string(data), tt.want; is != want {
+				t.Fatalf("is = %q, want = %q", is, want)
+			}
+		})
+	}
+}
+
+func TestLogFiles_Merge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	svc := config.Service{Name: "test", Log: &config.Log{Path: path, Merge: true}}
+
+	files := NewLogFiles()
+	stdout := files.Stdout(svc)
+	stderr := files.Stderr(svc)
+
+	_, _ = stdout.Write([]byte("half of "))
+	_, _ = stderr.Write([]byte("error\n"))
+	_, _ = stdout.Write([]byte("a line\n"))
+
+	if err := files.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if is, want := string(data), "error\nhalf of a line\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
@@ -1,53 +1,65 @@
 package output
 
 import (
-	"bytes"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"mrz.io/hkswitch/app/config"
+	"time"
 )
 
+// ANSI escape sequences used to color prefixes.
+const (
+	colorReset = "\x1b[0m"
+	colorRed   = "\x1b[31m"
+)
+
+// prefixColors are the colors given to services, picked by a hash of their name. Red is left for stderr.
+var prefixColors = []string{
+	"\x1b[32m", "\x1b[33m", "\x1b[34m", "\x1b[35m", "\x1b[36m",
+	"\x1b[92m", "\x1b[93m", "\x1b[94m", "\x1b[95m", "\x1b[96m",
+}
+
 func Prefix(s string, n int, sep string) string {
 	return fmt.Sprintf("%-"+fmt.Sprintf("%d", n)+"s%s", s, sep)
 }
 
-func WithPrefix(w io.Writer, prefix string) io.Writer {
-	return &prefixer{w: w, prefix: prefix, writePrefix: true}
+// WithPrefix returns a LineWriter writing each line to w prefixed by prefix.
+func WithPrefix(w io.Writer, prefix string) *LineWriter {
+	return WithTimestamp(w, prefix, "")
 }
 
-type prefixer struct {
-	w           io.Writer
-	prefix      string
-	writePrefix bool
+// WithTimestamp returns a LineWriter writing each line to w prefixed by the time it was written, formatted with
+// layout as in time.Format, and prefix. There is no time when layout is empty.
+func WithTimestamp(w io.Writer, prefix string, layout string) *LineWriter {
+	return newLineWriter(NewSink(w), prefixFormatter(prefix, layout))
 }
 
-func (p *prefixer) Write(data []byte) (n int, err error) {
-	buf := bytes.NewBuffer(nil)
-
-	for _, b := range data {
-		if p.writePrefix {
-			buf.WriteString(p.prefix)
-			p.writePrefix = false
+func prefixFormatter(prefix string, layout string) formatter {
+	return func(dst []byte, line []byte) []byte {
+		if layout != "" {
+			dst = time.Now().AppendFormat(dst, layout)
+			dst = append(dst, ' ')
 		}
 
-		buf.WriteByte(b)
-
-		if b == '\n' {
-			p.writePrefix = true
-		}
+		dst = append(dst, prefix...)
+		dst = append(dst, line...)
+		return append(dst, '\n')
 	}
+}
 
-	dataLen := len(data)
+// Style sets how a Prefixer decorates lines.
+type Style struct {
+	// TimestampFormat is the layout, as in time.Format, of the time written at the start of each line. There is no
+	// time when empty.
+	TimestampFormat string
 
-	if n, err := p.w.Write(buf.Bytes()); err != nil {
-		if n <= dataLen {
-			return n, err
-		} else {
-			return dataLen, err
-		}
-	}
+	// Color gives the name of each service a color of its own, and colors the separator of stderr lines in red.
+	Color bool
 
-	return dataLen, nil
+	// StderrSep is the separator used for stderr lines, to tell them from stdout lines. The Prefixer's separator is
+	// used when empty.
+	StderrSep string
 }
 
 type Prefixer struct {
@@ -56,18 +68,55 @@ type Prefixer struct {
 
 	minLen int
 	sep    string
+	style  Style
 }
 
-func NewPrefixer(stdout io.Writer, stderr io.Writer, minLen int, sep string) *Prefixer {
-	return &Prefixer{stdout: stdout, stderr: stderr, minLen: minLen, sep: sep}
+// NewPrefixer creates a Prefixer writing to stdout and stderr. Pass the same *Sink used by other writers of stdout
+// and stderr, if any, to have whole lines written.
+func NewPrefixer(stdout io.Writer, stderr io.Writer, minLen int, sep string, style Style) *Prefixer {
+	return &Prefixer{stdout: NewSink(stdout), stderr: NewSink(stderr), minLen: minLen, sep: sep, style: style}
 }
 
 func (p Prefixer) Stdout(svc config.Service) io.Writer {
-	return WithPrefix(p.stdout, Prefix(svc.Name, p.minLen, p.sep))
+	return p.writer(p.stdout, svc.Name, p.sep, "")
 }
 
 func (p Prefixer) Stderr(svc config.Service) io.Writer {
-	return WithPrefix(p.stderr, Prefix(svc.Name, p.minLen, p.sep))
+	sep := p.style.StderrSep
+	if sep == "" {
+		sep = p.sep
+	}
+
+	return p.writer(p.stderr, svc.Name, sep, colorRed)
+}
+
+// Messages returns a writer of messages to stderr, prefixed by name like the output of the services.
+func (p Prefixer) Messages(name string) io.Writer {
+	return p.writer(p.stderr, name, p.sep, "")
+}
+
+func (p Prefixer) writer(w io.Writer, name string, sep string, sepColor string) io.Writer {
+	prefix := Prefix(name, p.minLen, sep)
+
+	if p.style.Color {
+		// padded before adding the escape sequences, so that colored prefixes are aligned
+		padded := Prefix(name, p.minLen, "")
+		prefix = colorOf(name) + padded + colorReset + sep
+
+		if sepColor != "" {
+			prefix = colorOf(name) + padded + colorReset + sepColor + sep + colorReset
+		}
+	}
+
+	return WithTimestamp(w, prefix, p.style.TimestampFormat)
+}
+
+// colorOf returns the color of the service with the given name, which is the same across runs.
+func colorOf(name string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+
+	return prefixColors[h.Sum32()%uint32(len(prefixColors))]
 }
 
 func FindPrefixSize(minLen int, names ...string) int {
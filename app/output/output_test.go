@@ -2,7 +2,14 @@ package output
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mrz.io/hkswitch/app/config"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestPrefix(t *testing.T) {
@@ -19,8 +26,238 @@ func TestWithPrefix(t *testing.T) {
 	_, _ = pw.Write([]byte("hello\nw"))
 	_, _ = pw.Write([]byte("orld"))
 
-	want := "prfx: hello\nprfx: world"
+	want := "prfx: hello\n"
 	if is := buf.String(); is != want {
 		t.Fatalf("is = %q, want = %q", is, want)
 	}
+
+	_ = pw.Flush()
+
+	want = "prfx: hello\nprfx: world\n"
+	if is := buf.String(); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestWithPrefix_CarriageReturn(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	pw := WithPrefix(buf, "prfx: ")
+
+	_, _ = pw.Write([]byte("10%\r20%\r"))
+	_, _ = pw.Write([]byte("30%\r\ndone\r\n"))
+
+	want := "prfx: 30%\nprfx: done\n"
+	if is := buf.String(); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestWithPrefix_Timeout(t *testing.T) {
+	buf := &safeBuffer{}
+
+	pw := WithPrefix(buf, "prfx: ")
+
+	_, _ = pw.Write([]byte("waiting..."))
+	time.Sleep(2 * partialLineTimeout)
+	_, _ = pw.Write([]byte("done\n"))
+
+	want := "prfx: waiting...\nprfx: done\n"
+	if is := buf.String(); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestWithPrefix_TimeoutNewline(t *testing.T) {
+	buf := &safeBuffer{}
+
+	pw := WithPrefix(buf, "prfx: ")
+
+	_, _ = pw.Write([]byte("Password: "))
+	time.Sleep(2 * partialLineTimeout)
+	_, _ = pw.Write([]byte("\nok\n\n"))
+
+	// the newline ending the line written after the timeout doesn't make an empty line, the ones after it do
+	want := "prfx: Password: \nprfx: ok\nprfx: \n"
+	if is := buf.String(); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	_, _ = pw.Write([]byte("Continue? "))
+	time.Sleep(2 * partialLineTimeout)
+	_, _ = pw.Write([]byte("\r\n"))
+
+	want += "prfx: Continue? \n"
+	if is := buf.String(); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestWithPrefix_LongLine(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	pw := WithPrefix(buf, "prfx: ")
+
+	_, _ = pw.Write(bytes.Repeat([]byte("a"), maxLineLen+1))
+	_ = pw.Flush()
+
+	want := "prfx: " + strings.Repeat("a", maxLineLen) + "\nprfx: a\n"
+	if is := buf.String(); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestWithPrefix_Concurrent(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sink := NewSink(buf)
+
+	wg := sync.WaitGroup{}
+
+	for _, prefix := range []string{"a: ", "b: "} {
+		pw := WithPrefix(sink, prefix)
+		wg.Add(1)
+
+		go func(prefix string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				_, _ = pw.Write([]byte(prefix[:1]))
+				_, _ = pw.Write([]byte(prefix[:1] + "\n"))
+			}
+		}(prefix)
+	}
+
+	wg.Wait()
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if line != "a: aa" && line != "b: bb" {
+			t.Fatalf("is = %q, want = %q or %q", line, "a: aa", "b: bb")
+		}
+	}
+}
+
+func TestWithTimestamp(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	_, _ = WithTimestamp(buf, "prfx: ", "2006").Write([]byte("hello\n"))
+
+	want := fmt.Sprintf("%d prfx: hello\n", time.Now().Year())
+	if is := buf.String(); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestPrefixer_Style(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	p := NewPrefixer(stdout, stderr, 4, " | ", Style{StderrSep: " ! "})
+	svc := config.Service{Name: "svc"}
+
+	_, _ = p.Stdout(svc).Write([]byte("out\n"))
+	_, _ = p.Stderr(svc).Write([]byte("err\n"))
+	_, _ = p.Messages("app").Write([]byte("msg\n"))
+
+	if is, want := stdout.String(), "svc  | out\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	if is, want := stderr.String(), "svc  ! err\napp  | msg\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestPrefixer_Color(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	p := NewPrefixer(stdout, stderr, 4, " | ", Style{Color: true, StderrSep: " ! "})
+	svc := config.Service{Name: "svc"}
+
+	_, _ = p.Stdout(svc).Write([]byte("out\n"))
+	_, _ = p.Stderr(svc).Write([]byte("err\n"))
+
+	color := colorOf("svc")
+
+	if is, want := stdout.String(), color+"svc "+colorReset+" | out\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	if is, want := stderr.String(), color+"svc "+colorReset+colorRed+" ! "+colorReset+"err\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func BenchmarkWithPrefix(b *testing.B) {
+	benchmarkPrefixer(b, WithPrefix(ioutil.Discard, "prfx: "))
+}
+
+func BenchmarkWithPrefix_Legacy(b *testing.B) {
+	benchmarkPrefixer(b, &legacyPrefixer{w: ioutil.Discard, prefix: "prfx: ", writePrefix: true})
+}
+
+func benchmarkPrefixer(b *testing.B, w io.Writer) {
+	data := []byte("a line of output from a service\nanother line, a bit longer than the first one\n")
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+
+	for i := 0; i < b.N; i++ {
+		_, _ = w.Write(data)
+	}
+}
+
+// legacyPrefixer is the previous implementation of WithPrefix, kept to compare the benchmarks.
+type legacyPrefixer struct {
+	w           io.Writer
+	prefix      string
+	writePrefix bool
+}
+
+func (p *legacyPrefixer) Write(data []byte) (n int, err error) {
+	buf := bytes.NewBuffer(nil)
+
+	for _, b := range data {
+		if p.writePrefix {
+			buf.WriteString(p.prefix)
+			p.writePrefix = false
+		}
+
+		buf.WriteByte(b)
+
+		if b == '\n' {
+			p.writePrefix = true
+		}
+	}
+
+	dataLen := len(data)
+
+	if n, err := p.w.Write(buf.Bytes()); err != nil {
+		if n <= dataLen {
+			return n, err
+		} else {
+			return dataLen, err
+		}
+	}
+
+	return dataLen, nil
+}
+
+// safeBuffer is a bytes.Buffer safe for concurrent use.
+type safeBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *safeBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(data)
+}
+
+func (b *safeBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
 }
@@ -0,0 +1,112 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// ProgressPattern matches the lines of the progress protocol understood without configuration, e.g.
+// "HKSWITCH_PROGRESS=42" for 42%.
+var ProgressPattern = regexp.MustCompile(`HKSWITCH_PROGRESS=(\d+(?:\.\d+)?)`)
+
+// progressWriter parses the progress of a job from the lines written to it.
+type progressWriter struct {
+	patterns []*regexp.Regexp
+	report   func(percent float64)
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewProgressWriter returns an io.Writer calling report with the progress found in the lines written to it, between
+// 0 and 100. A line reports progress when it matches ProgressPattern, or pattern if not nil: with one group, it's the
+// percentage; with two, the number of steps done and the total. Lines end with "\n" or "\r", so that each redraw
+// of a progress bar is parsed.
+func NewProgressWriter(pattern *regexp.Regexp, report func(percent float64)) io.Writer {
+	w := &progressWriter{patterns: []*regexp.Regexp{ProgressPattern}, report: report}
+	if pattern != nil {
+		w.patterns = append(w.patterns, pattern)
+	}
+
+	return w
+}
+
+func (w *progressWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, data...)
+
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+
+		w.parse(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+
+	if len(w.buf) > maxLineLen {
+		w.buf = w.buf[:0]
+	}
+
+	return len(data), nil
+}
+
+// parse reports the progress in line, the last one if there are several.
+func (w *progressWriter) parse(line []byte) {
+	for _, pattern := range w.patterns {
+		matches := pattern.FindAllSubmatch(line, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		if percent, ok := progressPercent(matches[len(matches)-1]); ok {
+			w.report(percent)
+			return
+		}
+	}
+}
+
+// progressPercent returns the percentage from the groups of a match: the percentage itself, or the number of steps
+// done and the total.
+func progressPercent(match [][]byte) (float64, bool) {
+	var percent float64
+
+	switch len(match) {
+	case 2:
+		value, err := strconv.ParseFloat(string(match[1]), 64)
+		if err != nil {
+			return 0, false
+		}
+
+		percent = value
+	case 3:
+		done, err := strconv.ParseFloat(string(match[1]), 64)
+		if err != nil {
+			return 0, false
+		}
+
+		total, err := strconv.ParseFloat(string(match[2]), 64)
+		if err != nil || total <= 0 {
+			return 0, false
+		}
+
+		percent = done * 100 / total
+	default:
+		return 0, false
+	}
+
+	switch {
+	case percent < 0:
+		return 0, true
+	case percent > 100:
+		return 100, true
+	default:
+		return percent, true
+	}
+}
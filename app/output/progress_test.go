@@ -0,0 +1,69 @@
+package output
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestProgressWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern *regexp.Regexp
+		writes  []string
+		want    []float64
+	}{
+		{
+			name:   "protocol",
+			writes: []string{"starting\nHKSWITCH_PROGRESS=42\n", "HKSWITCH_PROGRESS=99.5\n"},
+			want:   []float64{42, 99.5},
+		},
+		{
+			name:   "split lines",
+			writes: []string{"HKSWITCH_PRO", "GRESS=7", "0\n"},
+			want:   []float64{70},
+		},
+		{
+			name:    "percentage",
+			pattern: regexp.MustCompile(`(\d+)% done`),
+			writes:  []string{"copying\r10% done\r20% done\r", "HKSWITCH_PROGRESS=30\n"},
+			want:    []float64{10, 20, 30},
+		},
+		{
+			name:    "steps",
+			pattern: regexp.MustCompile(`file (\d+) of (\d+)`),
+			writes:  []string{"file 1 of 4\nfile 4 of 4\nfile 1 of 0\n"},
+			want:    []float64{25, 100},
+		},
+		{
+			name:    "last match",
+			pattern: regexp.MustCompile(`(\d+)%`),
+			writes:  []string{"10% 20% 30%\n"},
+			want:    []float64{30},
+		},
+		{
+			name:    "clamped",
+			pattern: regexp.MustCompile(`at (\d+)`),
+			writes:  []string{"at 150\n"},
+			want:    []float64{100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var percents []float64
+
+			w := NewProgressWriter(tt.pattern, func(percent float64) {
+				percents = append(percents, percent)
+			})
+
+			for _, s := range tt.writes {
+				_, _ = w.Write([]byte(s))
+			}
+
+			if is, want := percents, tt.want; !reflect.DeepEqual(is, want) {
+				t.Fatalf("is = %v, want = %v", is, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package output
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mask replaces the redacted text.
+var mask = []byte("***")
+
+// Redactor masks secret values, and text matching a list of patterns.
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor returns a Redactor masking secrets, also when quoted like the program's arguments in the "starting"
+// message, and matches of patterns. It returns nil when there is nothing to mask.
+func NewRedactor(secrets []string, patterns []*regexp.Regexp) *Redactor {
+	seen := make(map[string]bool)
+
+	var literals []string
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+
+		for _, s := range []string{secret, quoted(strconv.Quote(secret)), quoted(strconv.QuoteToASCII(secret))} {
+			if !seen[s] {
+				seen[s] = true
+				literals = append(literals, regexp.QuoteMeta(s))
+			}
+		}
+	}
+
+	if len(literals) > 0 {
+		// longest first, so that a secret containing another one is masked in whole
+		sort.Slice(literals, func(i, j int) bool { return len(literals[i]) > len(literals[j]) })
+		patterns = append([]*regexp.Regexp{regexp.MustCompile(strings.Join(literals, "|"))}, patterns...)
+	}
+
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	return &Redactor{patterns: patterns}
+}
+
+func quoted(s string) string {
+	return s[1 : len(s)-1]
+}
+
+// Redact masks the secrets in line.
+func (r *Redactor) Redact(line []byte) []byte {
+	for _, re := range r.patterns {
+		line = re.ReplaceAllLiteral(line, mask)
+	}
+
+	return line
+}
+
+// RedactString masks the secrets in s. It returns s when r is nil.
+func (r *Redactor) RedactString(s string) string {
+	if r == nil {
+		return s
+	}
+
+	return string(r.Redact([]byte(s)))
+}
+
+// RedactLines returns a copy of lines with the secrets masked in their text. It returns lines when r is nil.
+func (r *Redactor) RedactLines(lines []Line) []Line {
+	if r == nil {
+		return lines
+	}
+
+	redacted := make([]Line, len(lines))
+	for i, line := range lines {
+		line.Text = r.RedactString(line.Text)
+		redacted[i] = line
+	}
+
+	return redacted
+}
+
+// Redact returns a LineWriter masking r's secrets in each line before writing it to w, so that a secret split across
+// two writes is masked too. It returns w when r is nil.
+func Redact(w io.Writer, r *Redactor) io.Writer {
+	if r == nil {
+		return w
+	}
+
+	return newLineWriter(w, redactFormatter(r))
+}
+
+func redactFormatter(r *Redactor) formatter {
+	return func(dst []byte, line []byte) []byte {
+		dst = append(dst, r.Redact(line)...)
+		return append(dst, '\n')
+	}
+}
@@ -0,0 +1,70 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := NewRedactor([]string{"s3cr3t", "s3cr3t-long", ""}, []*regexp.Regexp{regexp.MustCompile(`Bearer \S+`)})
+
+	buf := &bytes.Buffer{}
+	w := Redact(buf, r)
+
+	_, _ = w.Write([]byte("token is s3c"))
+	_, _ = w.Write([]byte("r3t\nAuthorization: Bearer abc.def\n"))
+	_, _ = w.Write([]byte("long: s3cr3t-long\n"))
+
+	want := "token is ***\nAuthorization: ***\nlong: ***\n"
+	if is := buf.String(); is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestRedact_Quoted(t *testing.T) {
+	secret := `pa"ss`
+	r := NewRedactor([]string{secret}, nil)
+
+	is := string(r.Redact([]byte(fmt.Sprintf("starting with args %+q", []string{"--password", secret}))))
+	want := `starting with args ["--password" "***"]`
+
+	if is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestRedact_Nothing(t *testing.T) {
+	if is := NewRedactor([]string{""}, nil); is != nil {
+		t.Fatalf("is = %v, want = %v", is, nil)
+	}
+
+	buf := &bytes.Buffer{}
+	if is, want := Redact(buf, nil), io.Writer(buf); is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestRedactor_RedactLines(t *testing.T) {
+	lines := []Line{{Stream: "stdout", Text: "token s3cr3t"}, {Stream: "stderr", Text: "done"}}
+
+	is := NewRedactor([]string{"s3cr3t"}, nil).RedactLines(lines)
+	want := []Line{{Stream: "stdout", Text: "token ***"}, {Stream: "stderr", Text: "done"}}
+
+	if !reflect.DeepEqual(is, want) {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	// the recorded lines are left as they are
+	if is, want := lines[0].Text, "token s3cr3t"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	var r *Redactor
+	if is, want := r.RedactString("s3cr3t"), "s3cr3t"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
@@ -0,0 +1,104 @@
+package output
+
+import (
+	"io"
+	"mrz.io/hkswitch/app/config"
+	"os"
+	"strconv"
+	"time"
+)
+
+// facilityDaemon is the syslog facility of the messages.
+const facilityDaemon = 3
+
+// defaultSyslogSockets are the local syslog sockets tried, in order, when no address is configured.
+var defaultSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Syslog is a StreamsFactory sending each line of output as an RFC 5424 message, with the service's name as
+// APP-NAME, and severity info for stdout and err for stderr.
+type Syslog struct {
+	datagramConns
+
+	hostname string
+	pid      string
+}
+
+func NewSyslog() *Syslog {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &Syslog{hostname: hostname, pid: strconv.Itoa(os.Getpid())}
+}
+
+func (s *Syslog) Stdout(svc config.Service) io.Writer {
+	return s.writer(svc, priorityInfo)
+}
+
+func (s *Syslog) Stderr(svc config.Service) io.Writer {
+	return s.writer(svc, priorityErr)
+}
+
+func (s *Syslog) writer(svc config.Service, severity int) io.Writer {
+	address := svc.Log.Address
+	if address == "" {
+		address = defaultSyslogAddress()
+	}
+
+	conn := s.get(address)
+	if conn == nil {
+		return io.Discard
+	}
+
+	return newLineWriter(conn, syslogFormatter(s.hostname, syslogAppName(svc.Name), s.pid, severity))
+}
+
+// syslogFormatter formats messages as <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG, with no
+// MSGID and STRUCTURED-DATA.
+func syslogFormatter(hostname, appName, pid string, severity int) formatter {
+	pri := "<" + strconv.Itoa(facilityDaemon*8+severity) + ">1 "
+
+	return func(dst []byte, line []byte) []byte {
+		dst = append(dst, pri...)
+		dst = time.Now().AppendFormat(dst, "2006-01-02T15:04:05.000000Z07:00")
+		dst = append(dst, ' ')
+		dst = append(dst, hostname...)
+		dst = append(dst, ' ')
+		dst = append(dst, appName...)
+		dst = append(dst, ' ')
+		dst = append(dst, pid...)
+		dst = append(dst, " - - "...)
+		return append(dst, line...)
+	}
+}
+
+// syslogAppName makes name a valid APP-NAME: at most 48 printable ASCII characters, with no spaces.
+func syslogAppName(name string) string {
+	b := []byte(name)
+	if len(b) > 48 {
+		b = b[:48]
+	}
+
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+
+	if len(b) == 0 {
+		return "-"
+	}
+
+	return string(b)
+}
+
+func defaultSyslogAddress() string {
+	for _, path := range defaultSyslogSockets {
+		if _, err := os.Stat(path); err == nil {
+			return "unix://" + path
+		}
+	}
+
+	return "udp://localhost:514"
+}
@@ -0,0 +1,82 @@
+package output
+
+import (
+	"fmt"
+	"mrz.io/hkswitch/app/config"
+	"net"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := NewSyslog()
+	defer s.Close()
+
+	svc := config.Service{
+		Name: "my service",
+		Log:  &config.Log{Driver: config.LogDriverSyslog, Address: "udp://" + conn.LocalAddr().String()},
+	}
+
+	stdout := s.Stdout(svc)
+	stderr := s.Stderr(svc)
+
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = stdout.Write([]byte("hello\n"))
+	_, _ = stderr.Write([]byte("oops\n"))
+
+	pid := os.Getpid()
+	want := []*regexp.Regexp{
+		regexp.MustCompile(fmt.Sprintf(`^<30>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}\S+ \S+ my_service %d - - hello$`, pid)),
+		regexp.MustCompile(fmt.Sprintf(`^<27>1 \S+ \S+ my_service %d - - oops$`, pid)),
+	}
+
+	for _, w := range want {
+		if is := readDatagram(t, conn); !w.MatchString(is) {
+			t.Fatalf("is = %q, want = %s", is, w)
+		}
+	}
+}
+
+func TestSyslog_UnixSocket(t *testing.T) {
+	conn, address := listenUnixgram(t)
+
+	s := NewSyslog()
+	defer s.Close()
+
+	stdout := s.Stdout(config.Service{Name: "test", Log: &config.Log{Address: address}})
+	_, _ = stdout.Write([]byte("hello\n"))
+
+	re := regexp.MustCompile(`^<30>1 \S+ \S+ test \d+ - - hello$`)
+	if is := readDatagram(t, conn); !re.MatchString(is) {
+		t.Fatalf("is = %q, want = %s", is, re)
+	}
+}
+
+func TestParseDatagramAddress(t *testing.T) {
+	tests := []struct {
+		in, network, address string
+		err                  bool
+	}{
+		{in: "unix:///dev/log", network: "unixgram", address: "/dev/log"},
+		{in: "udp://localhost:514", network: "udp", address: "localhost:514"},
+		{in: "tcp://localhost:514", err: true},
+	}
+
+	for _, tt := range tests {
+		network, address, err := parseDatagramAddress(tt.in)
+		if (err != nil) != tt.err || network != tt.network || address != tt.address {
+			t.Fatalf("%s: is = %q, %q, %v, want = %q, %q, error %v", tt.in, network, address, err, tt.network,
+				tt.address, tt.err)
+		}
+	}
+}
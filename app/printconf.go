@@ -6,6 +6,7 @@ import (
 	"mrz.io/hkswitch/app/systemd"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func PrintConf(initType, configFile string, envVars []string) error {
@@ -39,7 +40,7 @@ func PrintConf(initType, configFile string, envVars []string) error {
 	}
 
 	conf := &systemd.Unit{
-		Description: cfg.Bridge.Name,
+		Description: strings.Join(bridgeNames(cfg), ", "),
 		WorkingDir:  workingDir,
 		CommandLine: fmt.Sprintf("%q %q", binPath, configPath),
 		Command:     []string{binPath, configFile},
@@ -59,3 +60,13 @@ func PrintConf(initType, configFile string, envVars []string) error {
 
 	return nil
 }
+
+// bridgeNames returns the names of the bridges in the configuration.
+func bridgeNames(cfg config.Config) []string {
+	var names []string
+	for _, bridgeCfg := range cfg.BridgeConfigs() {
+		names = append(names, bridgeCfg.Name)
+	}
+
+	return names
+}
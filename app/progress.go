@@ -0,0 +1,35 @@
+package app
+
+import (
+	"io"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/app/output"
+	"sync"
+)
+
+// progressReports relays the progress parsed from the output of the services to the bridge, once it's set.
+type progressReports struct {
+	mu     sync.Mutex
+	report func(svc string, percent float64)
+}
+
+// Writer returns the io.Writer parsing the progress from the output of the service, which must have Progress set.
+func (p *progressReports) Writer(svc config.Service) io.Writer {
+	return output.NewProgressWriter(svc.Progress.Regexp(), func(percent float64) {
+		p.mu.Lock()
+		report := p.report
+		p.mu.Unlock()
+
+		if report != nil {
+			report(svc.Name, percent)
+		}
+	})
+}
+
+// setReporter sets the function the progress is reported to.
+func (p *progressReports) setReporter(report func(svc string, percent float64)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.report = report
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"github.com/brutella/hc/log"
 	"io"
 	"mrz.io/hkswitch/app/config"
@@ -20,6 +21,9 @@ const (
 	Name         = "hkswitch"
 	manufacturer = "mrz.io"
 	separator    = " | "
+
+	// stderrSeparator replaces separator on the lines services write to stderr.
+	stderrSeparator = " ! "
 )
 
 func init() {
@@ -33,18 +37,20 @@ type StreamsFactory interface {
 	Stderr(svc config.Service) io.Writer
 }
 
+// Serve starts the services' bridge and blocks until ctx is done. When an upgrade is requested while serving, the
+// running services are handed over to a new hkswitch process that replaces the current one.
 func Serve(ctx context.Context, stdout, stderr io.Writer, configFile string) error {
 	cfg, err := config.Load(configFile)
 	if err != nil {
 		return err
 	}
 
-	// figure out the maximum output's left column size, based on the longest between
-	// the app's name and service names.
-	prefixLen := output.FindPrefixSize(len(Name), cfg.ServiceNames()...)
+	// hkswitch and the services share the same sinks, so that their lines are not mixed up
+	stdoutSink := output.NewSink(stdout)
+	stderrSink := output.NewSink(stderr)
 
-	// log.Debug.SetOutput(output.WithPrefix(os.Stderr, output.Prefix(Name, prefixLen, separator)))
-	log.Info.SetOutput(output.WithPrefix(os.Stderr, output.Prefix(Name, prefixLen, separator)))
+	redactor := newRedactor(cfg)
+	terminal := setupOutput(cfg, stdoutSink, stderrSink, terminalStyle(cfg, stdout), redactor)
 
 	if cfg.Metric.Address != "" {
 		metricsServer, err := metrics.NewServer(cfg.Metric.Address)
@@ -59,26 +65,159 @@ func Serve(ctx context.Context, stdout, stderr io.Writer, configFile string) err
 		}()
 	}
 
-	services, err := createServices(cfg, output.NewPrefixer(stdout, stderr, prefixLen, separator))
+	if err := service.StartReaper(log.Info.Writer()); err != nil {
+		log.Info.Printf("%s", err)
+	}
+
+	logFiles := output.NewLogFiles()
+	defer func() {
+		if err := logFiles.Close(); err != nil {
+			log.Info.Printf("log files: %s\n", err)
+		}
+	}()
+
+	journald := output.NewJournald()
+	defer func() {
+		if err := journald.Close(); err != nil {
+			log.Info.Printf("journald: %s\n", err)
+		}
+	}()
+
+	syslog := output.NewSyslog()
+	defer func() {
+		if err := syslog.Close(); err != nil {
+			log.Info.Printf("syslog: %s\n", err)
+		}
+	}()
+
+	history := output.NewHistory(cfg.HistoryLines)
+
+	sf := streams{
+		terminal: terminal,
+		files:    logFiles,
+		journald: journald,
+		syslog:   syslog,
+		history:  history,
+		redactor: redactor,
+		progress: &progressReports{},
+		buffered: &flushers{},
+	}
+
+	services, err := createServices(cfg, sf)
 	if err != nil {
 		return err
 	}
 
+	if err := logFiles.Err(); err != nil {
+		return err
+	}
+
+	if err := journald.Err(); err != nil {
+		return fmt.Errorf("journald: %w", err)
+	}
+
+	if err := syslog.Err(); err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+
 	mgr := service.NewManager()
 	metrics.ConsumeServiceStateChanges(mgr.Subscribe(ctx))
 
-	bridge, err := homekit.NewBridge(cfg, mgr, services...)
-	if err != nil {
-		return err
+	if cfg.LogFormat == "json" {
+		reportEvents(mgr.Subscribe(ctx), history, redactor, stderrSink)
+	} else {
+		reportFailures(mgr.Subscribe(ctx), history)
+	}
+
+	var bridges []*homekit.Bridge
+
+	for _, bridgeCfg := range cfg.BridgeConfigs() {
+		bridge, err := homekit.NewBridge(bridgeCfg, mgr, services...)
+		if err != nil {
+			return err
+		}
+
+		bridges = append(bridges, bridge)
 	}
 
-	shutdownOnCtxDone(ctx, bridge, mgr)
-	autostart(mgr, services, cfg)
+	// a service is shown by one bridge, the others ignore its progress
+	sf.progress.setReporter(func(svc string, percent float64) {
+		for _, bridge := range bridges {
+			bridge.SetProgress(svc, percent)
+		}
+	})
+
+	handover := shutdownOnCtxDone(ctx, notifyUpgrade(), bridges, mgr)
+
+	// after an upgrade, the services that were running are adopted and the others are left stopped, as they were.
+	if !adoptInherited(mgr, services) {
+		autostart(mgr, services, cfg)
+	}
 
 	log.Info.Printf("starting bridge...")
-	err = bridge.Start()
+	err = startBridges(bridges)
 
-	return err
+	select {
+	case handles := <-handover:
+		// replacing the process from here, before the deferred calls close the sinks the output is written to
+		return reexec(handles, sf.flush)
+	default:
+		return err
+	}
+}
+
+// setupOutput sets where hkswitch's own messages go according to the configured log format, and returns the
+// StreamsFactory for the services' output to hkswitch's stdout and stderr.
+func setupOutput(cfg config.Config, stdout, stderr io.Writer, style output.Style,
+	redactor *output.Redactor) StreamsFactory {
+	if cfg.LogFormat == "json" {
+		// time and source are not part of the message
+		log.Info.SetFlags(0)
+		log.Info.SetPrefix("")
+		log.Info.SetOutput(output.Redact(output.WithJSON(stderr, Name, "stderr"), redactor))
+
+		return output.NewJSON(stdout, stderr)
+	}
+
+	// figure out the maximum output's left column size, based on the longest between
+	// the app's name and service names.
+	prefixLen := output.FindPrefixSize(len(Name), cfg.ServiceNames()...)
+
+	prefixer := output.NewPrefixer(stdout, stderr, prefixLen, separator, style)
+
+	// log.Debug.SetOutput(prefixer.Messages(Name))
+	log.Info.SetOutput(output.Redact(prefixer.Messages(Name), redactor))
+
+	return prefixer
+}
+
+// terminalStyle returns how lines written to the terminal are decorated: colors are used when stdout is a terminal
+// and NO_COLOR is not set (see https://no-color.org), unless the color setting says otherwise.
+func terminalStyle(cfg config.Config, stdout io.Writer) output.Style {
+	style := output.Style{TimestampFormat: cfg.TimestampFormat, StderrSep: stderrSeparator}
+
+	switch cfg.Color {
+	case "always":
+		style.Color = true
+	case "never":
+		style.Color = false
+	default:
+		f, ok := stdout.(*os.File)
+		style.Color = ok && isTerminal(f) && os.Getenv("NO_COLOR") == ""
+	}
+
+	return style
+}
+
+// newRedactor returns the Redactor masking the secrets of all services and the configured patterns in all output,
+// or nil if there is nothing to mask.
+func newRedactor(cfg config.Config) *output.Redactor {
+	var secrets []string
+	for _, svc := range cfg.Services {
+		secrets = append(secrets, svc.SecretValues()...)
+	}
+
+	return output.NewRedactor(secrets, cfg.RedactPatterns())
 }
 
 func autostart(mgr *service.Manager, services []service.Service, cfg config.Config) {
@@ -88,15 +227,65 @@ func autostart(mgr *service.Manager, services []service.Service, cfg config.Conf
 	}
 
 	log.Info.Printf("startup services: %+q", startupServices)
-	mgr.Start(startupServices...)
+
+	for i, svcCfg := range cfg.Services {
+		switch {
+		case !svcCfg.Autostart:
+		case svcCfg.Replicas != nil:
+			mgr.Scale(svcCfg.Replicas.Lowest(), services[i])
+		default:
+			mgr.Start(services[i])
+		}
+	}
 }
 
-func shutdownOnCtxDone(ctx context.Context, bridge *homekit.Bridge, mgr *service.Manager) {
-	go func() {
-		<-ctx.Done()
-		mgr.Shutdown()
+// startBridges starts the bridges, blocking until all of them have stopped. When one of them stops on its own, eg.
+// because its transport failed, the others are stopped too, and its error is returned.
+func startBridges(bridges []*homekit.Bridge) error {
+	errs := make(chan error, len(bridges))
+
+	for _, bridge := range bridges {
+		go func(bridge *homekit.Bridge) {
+			errs <- bridge.Start()
+		}(bridge)
+	}
+
+	err := <-errs
+
+	for _, bridge := range bridges {
 		bridge.Stop()
+	}
+
+	for i := 1; i < len(bridges); i++ {
+		if e := <-errs; err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// shutdownOnCtxDone stops the services and the bridges when ctx is done. When an upgrade is requested instead, the
+// bridges are stopped leaving the services running, and their Handles are written to the returned channel.
+func shutdownOnCtxDone(ctx context.Context, upgrade <-chan os.Signal, bridges []*homekit.Bridge,
+	mgr *service.Manager) <-chan map[service.Service][]service.Handle {
+	handover := make(chan map[service.Service][]service.Handle, 1)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			mgr.Shutdown()
+		case <-upgrade:
+			log.Info.Printf("upgrade requested, handing over running services...")
+			handover <- mgr.Handover()
+		}
+
+		for _, bridge := range bridges {
+			bridge.Stop()
+		}
 	}()
+
+	return handover
 }
 
 func getStartupServices(services []service.Service, cfg config.Config) []service.Service {
@@ -120,17 +309,40 @@ func createServices(cfg config.Config, sf StreamsFactory) ([]service.Service, er
 		}
 
 		cmd := &service.Command{
-			Path:        svcCfg.Command[0],
-			Args:        svcCfg.Command[1:],
 			Workdir:     svcCfg.Workdir,
 			Env:         svcCfg.Env,
 			StopSignal:  sig,
 			GracePeriod: 5 * time.Second,
+
+			KillOnParentDeath: svcCfg.KillOnParentDeath,
+
+			TTY:  svcCfg.TTY,
+			Rows: svcCfg.TTYRows,
+			Cols: svcCfg.TTYCols,
+		}
+
+		if len(svcCfg.Command) > 0 {
+			cmd.Path = svcCfg.Command[0]
+			cmd.Args = svcCfg.Command[1:]
 		}
 
-		svc := service.NewDaemon(svcCfg.Name, cmd, sf.Stdout(svcCfg), sf.Stderr(svcCfg))
+		if len(svcCfg.Variants) == 0 {
+			list = append(list, service.NewDaemon(svcCfg.Name, cmd, sf.Stdout(svcCfg), sf.Stderr(svcCfg)))
+			continue
+		}
+
+		var variants []service.Variant
+
+		for _, v := range svcCfg.Variants {
+			variantCmd := *cmd
+			variantCmd.Path = v.Command[0]
+			variantCmd.Args = v.Command[1:]
+			variantCmd.Env = append(append([]string{}, svcCfg.Env...), v.Env...)
+
+			variants = append(variants, service.Variant{Name: v.Name, Cmd: &variantCmd})
+		}
 
-		list = append(list, svc)
+		list = append(list, service.NewVariantDaemon(svcCfg.Name, variants, sf.Stdout(svcCfg), sf.Stderr(svcCfg)))
 	}
 
 	return list, nil
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"io"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/app/output"
+	"sync"
+)
+
+// streams is a StreamsFactory choosing where the output of each service goes, based on its configuration.
+type streams struct {
+	// terminal writes to hkswitch's stdout and stderr.
+	terminal StreamsFactory
+
+	files    StreamsFactory
+	journald StreamsFactory
+	syslog   StreamsFactory
+
+	// history records the output of all services, in addition to where it goes.
+	history StreamsFactory
+
+	// redactor masks secrets in the output before it goes anywhere, nil if there are none.
+	redactor *output.Redactor
+
+	// progress parses the progress from the output of the services that have Progress set.
+	progress *progressReports
+
+	// buffered are the writers holding partial lines, or queued data, to flush before an upgrade.
+	buffered *flushers
+}
+
+func (s streams) Stdout(svc config.Service) io.Writer {
+	w := s.factory(svc).Stdout(svc)
+	redacted := output.Redact(s.tee(svc, w, s.history.Stdout(svc)), s.redactor)
+	s.buffered.add(redacted, w)
+
+	return redacted
+}
+
+func (s streams) Stderr(svc config.Service) io.Writer {
+	w := s.factory(svc).Stderr(svc)
+	redacted := output.Redact(s.tee(svc, w, s.history.Stderr(svc)), s.redactor)
+	s.buffered.add(redacted, w)
+
+	return redacted
+}
+
+// flush writes what the services' writers hold, so that no output is lost when hkswitch replaces itself. The
+// programs' output must not be copied to the writers anymore.
+func (s streams) flush() {
+	s.buffered.flush()
+
+	if f, ok := s.files.(flusher); ok {
+		_ = f.Flush()
+	}
+}
+
+// tee returns an io.Writer writing to all writers, and to the progress parser if the service has Progress set.
+func (s streams) tee(svc config.Service, writers ...io.Writer) io.Writer {
+	if svc.Progress != nil && s.progress != nil {
+		writers = append(writers, s.progress.Writer(svc))
+	}
+
+	return io.MultiWriter(writers...)
+}
+
+func (s streams) factory(svc config.Service) StreamsFactory {
+	if svc.Log == nil {
+		return s.terminal
+	}
+
+	switch svc.Log.Driver {
+	case config.LogDriverJournald:
+		return s.journald
+	case config.LogDriverSyslog:
+		return s.syslog
+	default:
+		return s.files
+	}
+}
+
+// flusher is implemented by writers buffering what is written to them, like output.LineWriter.
+type flusher interface {
+	Flush() error
+}
+
+// flushers records the writers that buffer what is written to them.
+type flushers struct {
+	mu   sync.Mutex
+	list []flusher
+}
+
+// add records the writers that are flushers, in order: writers must be added before the ones they write to.
+func (f *flushers) add(writers ...io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, w := range writers {
+		if fl, ok := w.(flusher); ok {
+			f.list = append(f.list, fl)
+		}
+	}
+}
+
+// flush flushes the writers in the order they were added.
+func (f *flushers) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, fl := range f.list {
+		_ = fl.Flush()
+	}
+}
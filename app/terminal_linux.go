@@ -0,0 +1,14 @@
+// +build linux
+
+package app
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
@@ -0,0 +1,10 @@
+// +build windows
+
+package app
+
+import "os"
+
+// isTerminal returns false: colors are not supported on Windows consoles.
+func isTerminal(f *os.File) bool {
+	return false
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"encoding/json"
+	"github.com/brutella/hc/log"
+	"mrz.io/hkswitch/service"
+	"os"
+	"syscall"
+)
+
+// inheritEnv is the environment variable used to pass the running services to the new hkswitch process on upgrade.
+const inheritEnv = "HKSWITCH_INHERIT"
+
+// inheritedService is a running service's program as passed to the new hkswitch process on upgrade. Stdout and
+// Stderr are file descriptors left open across exec(2), zero when the program has no such pipe (fd 0 is hkswitch's
+// own stdin, never one of the pipes). Variant is the selected variant of a service.Selector. Restart is set instead
+// for a program that couldn't be handed over, stopped before the upgrade and started again by the new process.
+type inheritedService struct {
+	Name    string  `json:"name"`
+	Pid     int     `json:"pid"`
+	Stdout  uintptr `json:"stdout"`
+	Stderr  uintptr `json:"stderr"`
+	Variant string  `json:"variant,omitempty"`
+	Restart bool    `json:"restart,omitempty"`
+}
+
+// encodeInheritance detaches the handed over Handles, returning the services to pass to the new hkswitch process,
+// the ones detached so far on error. Handles that can't be detached are stopped; those whose program would be killed
+// by the upgrade are stopped and passed to be restarted, waiting for them to exit.
+func encodeInheritance(handles map[service.Service][]service.Handle) ([]inheritedService, error) {
+	list := []inheritedService{}
+
+	for svc, instances := range handles {
+		var variant string
+		if selector, ok := svc.(service.Selector); ok {
+			variant = selector.Selected()
+		}
+
+		for _, h := range instances {
+			d, ok := h.(service.Detacher)
+			if !ok {
+				log.Info.Printf("upgrade: %s can't be handed over, stopping it", svc)
+				h.Stop()
+				continue
+			}
+
+			detached, ok := d.Detach()
+			if !ok {
+				log.Info.Printf("upgrade: %s is killed on parent death, restarting it", svc)
+				h.Stop()
+				_ = h.Wait()
+
+				list = append(list, inheritedService{Name: svc.Name(), Variant: variant, Restart: true})
+				continue
+			}
+
+			inherited := inheritedService{Name: svc.Name(), Pid: detached.Pid, Variant: variant}
+
+			stdout, err := inheritFile(detached.Stdout)
+			if err != nil {
+				return append(list, inherited), err
+			}
+
+			stderr, err := inheritFile(detached.Stderr)
+			if err != nil {
+				return append(list, inherited), err
+			}
+
+			inherited.Stdout = stdout
+			inherited.Stderr = stderr
+			list = append(list, inherited)
+		}
+	}
+
+	return list, nil
+}
+
+// abortUpgrade stops the handed over programs when the upgrade failed, as nothing would supervise them once hkswitch
+// exits. list are the ones detached, whose pids are logged.
+func abortUpgrade(handles map[service.Service][]service.Handle, list []inheritedService) {
+	for _, inherited := range list {
+		if !inherited.Restart {
+			log.Info.Printf("upgrade: failed, stopping %s (pid %d)", inherited.Name, inherited.Pid)
+		}
+	}
+
+	for _, instances := range handles {
+		for _, h := range instances {
+			h.Stop()
+		}
+	}
+
+	for _, instances := range handles {
+		for _, h := range instances {
+			_ = h.Wait()
+		}
+	}
+}
+
+// adoptInherited lets the Manager adopt the services passed by a previous hkswitch process, if any, returning true
+// if hkswitch was started by an upgrade.
+func adoptInherited(mgr *service.Manager, services []service.Service) bool {
+	data, ok := os.LookupEnv(inheritEnv)
+	if !ok {
+		return false
+	}
+
+	// the services started from now on must not see it
+	_ = os.Unsetenv(inheritEnv)
+
+	var list []inheritedService
+	if err := json.Unmarshal([]byte(data), &list); err != nil {
+		log.Info.Printf("upgrade: %s", err)
+		return true
+	}
+
+	byName := make(map[string]service.Service)
+	for _, svc := range services {
+		byName[svc.Name()] = svc
+	}
+
+	// the instances of a service are adopted together, as adopting a running service stops them
+	adopted := make(map[service.Service][]service.Handle)
+	restarted := make(map[service.Service]int)
+
+	for _, inherited := range list {
+		// the variant running before the upgrade, so that it's the one adopted or restarted
+		if selector, ok := byName[inherited.Name].(service.Selector); ok && inherited.Variant != "" {
+			if !selector.Select(inherited.Variant) {
+				log.Info.Printf("upgrade: %s has no variant %s anymore", inherited.Name, inherited.Variant)
+			}
+		}
+
+		if inherited.Restart {
+			if svc, ok := byName[inherited.Name]; ok {
+				restarted[svc]++
+			}
+
+			continue
+		}
+
+		d := service.Detached{
+			Pid:    inherited.Pid,
+			Stdout: openInheritedFile(inherited.Stdout, inherited.Name+" stdout"),
+			Stderr: openInheritedFile(inherited.Stderr, inherited.Name+" stderr"),
+		}
+
+		svc, ok := byName[inherited.Name].(service.Adopter)
+		if !ok {
+			log.Info.Printf("upgrade: %s (pid %d) is not configured anymore, stopping it", inherited.Name,
+				inherited.Pid)
+			stopInherited(d)
+			continue
+		}
+
+		handle, err := svc.Adopt(d)
+		if err != nil {
+			continue
+		}
+
+		adopted[byName[inherited.Name]] = append(adopted[byName[inherited.Name]], handle)
+	}
+
+	for svc, handles := range adopted {
+		mgr.Adopt(svc, handles...)
+	}
+
+	for svc, n := range restarted {
+		mgr.Scale(n, svc)
+	}
+
+	return true
+}
+
+// stopInherited terminates an inherited program that can't be adopted.
+func stopInherited(d service.Detached) {
+	if d.Stdout != nil {
+		_ = d.Stdout.Close()
+	}
+
+	if d.Stderr != nil {
+		_ = d.Stderr.Close()
+	}
+
+	process, err := os.FindProcess(d.Pid)
+	if err != nil {
+		return
+	}
+
+	_ = process.Signal(syscall.SIGTERM)
+
+	go func() {
+		_, _ = process.Wait()
+	}()
+}
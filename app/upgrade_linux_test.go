@@ -0,0 +1,304 @@
+// +build linux
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mrz.io/hkswitch/service"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestUpgrade_Twice(t *testing.T) {
+	cmd := &service.Command{
+		Path:        "bash",
+		Args:        []string{"-c", "i=0; while true; do i=$((i+1)); echo $i; sleep 0.005; done"},
+		GracePeriod: time.Second,
+	}
+
+	var outputs []*syncBuffer
+	newService := func() service.Service {
+		stdout := &syncBuffer{}
+		outputs = append(outputs, stdout)
+
+		return service.NewDaemon("counter", cmd, stdout, ioutil.Discard)
+	}
+
+	svc := newService()
+
+	mgr := service.NewManager()
+	mgr.Start(svc)
+	waitState(t, mgr, svc, service.StateRunning)
+
+	for upgrade := 1; upgrade <= 2; upgrade++ {
+		waitOutput(t, outputs[len(outputs)-1])
+
+		list, err := encodeInheritance(mgr.Handover())
+		if err != nil {
+			t.Fatalf("upgrade %d: is = %v, want = %v", upgrade, err, nil)
+		}
+
+		data, err := json.Marshal(execInherited(t, list))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Setenv(inheritEnv, string(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		svc = newService()
+
+		mgr = service.NewManager()
+		adoptInherited(mgr, []service.Service{svc})
+		waitState(t, mgr, svc, service.StateRunning)
+	}
+
+	for i := 0; i < 10; i++ {
+		waitOutput(t, outputs[len(outputs)-1])
+	}
+
+	mgr.Shutdown()
+
+	// no line lost or cut at either upgrade
+	var all bytes.Buffer
+	for _, output := range outputs {
+		all.WriteString(output.String())
+	}
+
+	for i, line := range strings.Split(strings.TrimSuffix(all.String(), "\n"), "\n") {
+		if is, want := line, strconv.Itoa(i+1); is != want {
+			t.Fatalf("is = %q, want = %q", is, want)
+		}
+	}
+}
+
+func TestAbortUpgrade(t *testing.T) {
+	cmd := &service.Command{Path: "sleep", Args: []string{"30"}, GracePeriod: time.Second}
+	svc := service.NewDaemon("sleeper", cmd, ioutil.Discard, ioutil.Discard)
+
+	mgr := service.NewManager()
+	defer mgr.Shutdown()
+
+	mgr.Start(svc)
+	waitState(t, mgr, svc, service.StateRunning)
+
+	handles := mgr.Handover()
+
+	list, err := encodeInheritance(handles)
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	// the exec failed: no one would supervise the detached program once hkswitch exits
+	stopped := make(chan struct{})
+	go func() {
+		abortUpgrade(handles, list)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("is = %v, want = %v", "running", "stopped")
+	}
+}
+
+func TestUpgrade_KillOnParentDeath(t *testing.T) {
+	cmd := &service.Command{Path: "sleep", Args: []string{"30"}, KillOnParentDeath: true, GracePeriod: time.Second}
+	svc := service.NewDaemon("pinned", cmd, ioutil.Discard, ioutil.Discard)
+
+	mgr := service.NewManager()
+	mgr.Start(svc)
+	waitState(t, mgr, svc, service.StateRunning)
+
+	handles := mgr.Handover()
+
+	list, err := encodeInheritance(handles)
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	want := []inheritedService{{Name: "pinned", Restart: true}}
+	if is := list; len(is) != 1 || is[0] != want[0] {
+		t.Fatalf("is = %+v, want = %+v", is, want)
+	}
+
+	// stopped before the upgrade, so that the exec can't kill it
+	stopped := make(chan struct{})
+	go func() {
+		_ = handles[svc][0].Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("is = %v, want = %v", "running", "stopped")
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Setenv(inheritEnv, string(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded := service.NewManager()
+	defer upgraded.Shutdown()
+
+	if is, want := adoptInherited(upgraded, []service.Service{svc}), true; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	waitState(t, upgraded, svc, service.StateRunning)
+
+	if is, want := upgraded.Instances(svc), 1; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestUpgrade_Variant(t *testing.T) {
+	newService := func() service.Service {
+		// killed on parent death, so that it's restarted instead of being adopted by the same test process
+		variants := []service.Variant{
+			{Name: "a", Cmd: &service.Command{Path: "sleep", Args: []string{"30"}, KillOnParentDeath: true}},
+			{Name: "b", Cmd: &service.Command{Path: "sleep", Args: []string{"31"}, KillOnParentDeath: true}},
+		}
+
+		return service.NewVariantDaemon("variants", variants, ioutil.Discard, ioutil.Discard)
+	}
+
+	svc := newService()
+	svc.(service.Selector).Select("b")
+
+	mgr := service.NewManager()
+	mgr.Start(svc)
+	waitState(t, mgr, svc, service.StateRunning)
+
+	list, err := encodeInheritance(mgr.Handover())
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	if is, want := list[0].Variant, "b"; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Setenv(inheritEnv, string(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new process' service, selecting the first variant until told otherwise
+	upgradedSvc := newService()
+
+	upgraded := service.NewManager()
+	defer upgraded.Shutdown()
+
+	adoptInherited(upgraded, []service.Service{upgradedSvc})
+	waitState(t, upgraded, upgradedSvc, service.StateRunning)
+
+	if is, want := upgradedSvc.(service.Selector).Selected(), "b"; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+// execInherited returns list as received by the new process after exec(2): the inherited file descriptors are
+// duplicated, sharing their flags, as the ones of the current process are still in use by the handles detached.
+// They must be non-blocking, for the new process to be able to stop reading them when upgraded in turn.
+func execInherited(t *testing.T, list []inheritedService) []inheritedService {
+	t.Helper()
+
+	dup := func(fd uintptr) uintptr {
+		if fd == 0 {
+			return 0
+		}
+
+		flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+
+		if is, want := flags&syscall.O_NONBLOCK != 0, true; is != want {
+			t.Fatalf("non-blocking: is = %v, want = %v", is, want)
+		}
+
+		newFd, err := syscall.Dup(int(fd))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return uintptr(newFd)
+	}
+
+	for i := range list {
+		list[i].Stdout = dup(list[i].Stdout)
+		list[i].Stderr = dup(list[i].Stderr)
+	}
+
+	return list
+}
+
+// waitOutput waits for something to be written to output after the call.
+func waitOutput(t *testing.T, output *syncBuffer) {
+	t.Helper()
+
+	n := len(output.String())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(output.String()) == n {
+		if time.Now().After(deadline) {
+			t.Fatalf("is = %v, want = %v", "no output", "output")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(data)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// waitState waits for the service to be in the given state, failing the test after a few seconds.
+func waitState(t *testing.T, mgr *service.Manager, svc service.Service, state service.State) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for mgr.State(svc) != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("is = %v, want = %v", mgr.State(svc), state)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,110 @@
+// +build linux darwin
+
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+	"mrz.io/hkswitch/service"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Upgrade asks the hkswitch process with the given pid to replace itself with the current hkswitch binary.
+func Upgrade(pid int) error {
+	if err := syscall.Kill(pid, syscall.SIGUSR2); err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+
+	return nil
+}
+
+// notifyUpgrade returns a channel receiving SIGUSR2, which requests an upgrade.
+func notifyUpgrade() <-chan os.Signal {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+
+	return ch
+}
+
+// reexec replaces the current process with the hkswitch binary found at the same path, passing it the handed over
+// services. flush is called once the programs' output isn't copied anymore, to write what's left in the sinks before
+// the exec. It only returns on error, once the handed over services are stopped.
+func reexec(handles map[service.Service][]service.Handle, flush func()) error {
+	list, err := encodeInheritance(handles)
+	if err == nil {
+		err = execInheriting(list, flush)
+	}
+
+	abortUpgrade(handles, list)
+
+	return fmt.Errorf("upgrade: %w", err)
+}
+
+// execInheriting executes the hkswitch binary, passing it list.
+func execInheriting(list []inheritedService, flush func()) error {
+	flush()
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	binPath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	log.Info.Printf("upgrade: executing %s", binPath)
+
+	env := append(os.Environ(), fmt.Sprintf("%s=%s", inheritEnv, data))
+
+	return syscall.Exec(binPath, os.Args, env)
+}
+
+// inheritFile clears the close-on-exec flag of f, returning its file descriptor. It's done through the raw
+// connection rather than Fd, which would switch f to blocking mode for the new process too, where blocking reads
+// can't be stopped by a deadline when it's upgraded in turn.
+func inheritFile(f *os.File) (uintptr, error) {
+	if f == nil {
+		return 0, nil
+	}
+
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+
+	var fd uintptr
+	var errno syscall.Errno
+
+	err = conn.Control(func(s uintptr) {
+		fd = s
+		_, _, errno = syscall.Syscall(syscall.SYS_FCNTL, s, syscall.F_SETFD, 0)
+	})
+
+	if err == nil && errno != 0 {
+		err = errno
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", f.Name(), err)
+	}
+
+	return fd, nil
+}
+
+// openInheritedFile returns the file for a file descriptor passed by the previous hkswitch process, or nil for
+// none. The file is set to non-blocking mode, as one passed by an older hkswitch may not be, so that its reads can be
+// stopped by a deadline.
+func openInheritedFile(fd uintptr, name string) *os.File {
+	if fd == 0 {
+		return nil
+	}
+
+	_ = syscall.SetNonblock(int(fd), true)
+
+	return os.NewFile(fd, name)
+}
@@ -0,0 +1,29 @@
+// +build windows
+
+package app
+
+import (
+	"fmt"
+	"mrz.io/hkswitch/service"
+	"os"
+)
+
+func Upgrade(pid int) error {
+	return fmt.Errorf("upgrade: not supported")
+}
+
+func notifyUpgrade() <-chan os.Signal {
+	return nil
+}
+
+func reexec(handles map[service.Service][]service.Handle, flush func()) error {
+	return fmt.Errorf("upgrade: not supported")
+}
+
+func inheritFile(f *os.File) (uintptr, error) {
+	return 0, fmt.Errorf("upgrade: not supported")
+}
+
+func openInheritedFile(fd uintptr, name string) *os.File {
+	return nil
+}
@@ -10,5 +10,6 @@ require (
 	github.com/prometheus/client_golang v0.9.3
 	github.com/sergi/go-diff v1.2.0 // indirect
 	github.com/spf13/cobra v1.1.3
+	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c
 	gopkg.in/yaml.v2 v2.4.0
 )
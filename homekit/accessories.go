@@ -0,0 +1,522 @@
+package homekit
+
+import (
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	hcservice "github.com/brutella/hc/service"
+	"math"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+)
+
+// serviceAccessory is the accessory exposing a service to HomeKit: turning it on and off starts and stops the
+// service, and it shows whether the service is running.
+type serviceAccessory struct {
+	*accessory.Accessory
+
+	power power
+
+	// inverted shows power on when the service is stopped.
+	inverted bool
+
+	// inUse, when set, reports whether the service is running on a characteristic other than power.
+	inUse func(running bool)
+
+	// current, when set, shows the state of the service, including its transitions, e.g. as the door opening while
+	// the service is starting.
+	current func(state service.State)
+
+	// obstruction, when set, reports that the service failed like StatusFault does.
+	obstruction func(fault bool)
+
+	// primary is the accessory's main service, e.g. the Switch of a switch, which the status characteristics are
+	// added to.
+	primary *hcservice.Service
+
+	statusFault *characteristic.StatusFault
+
+	// statusActive is always true: hkswitch has no disabled or quarantined services for it to reflect, and no
+	// health checks, so a configured service is always active whether it runs or not.
+	statusActive *characteristic.StatusActive
+
+	// exitEvent, when set, is the button of a Stateless Programmable Switch pressed when the service stops.
+	exitEvent *characteristic.ProgrammableSwitchEvent
+
+	// input, when set, is the Active Identifier of a television, the identifier of each of inputs being its index
+	// plus one.
+	input  *characteristic.ActiveIdentifier
+	inputs []string
+
+	// level, when set, shows and sets the number of instances of a service with replicas.
+	level    level
+	replicas config.Replicas
+
+	// progress, when set, shows the progress of the service's job, between 0 and 100.
+	progress func(percent float64)
+}
+
+// readOnly are the permissions of the characteristics showing something that can't be changed from HomeKit.
+var readOnly = []string{characteristic.PermRead, characteristic.PermEvents}
+
+// showState adds fn to the functions showing the state of the service.
+func (a *serviceAccessory) showState(fn func(state service.State)) {
+	prev := a.current
+	if prev == nil {
+		a.current = fn
+		return
+	}
+
+	a.current = func(state service.State) {
+		prev(state)
+		fn(state)
+	}
+}
+
+// addProgress adds the service showing the progress of the service's job, as the level of a battery charging while
+// the service runs, or as the brightness of a lightbulb on while it runs. The progress is reset when it starts.
+func (a *serviceAccessory) addProgress(show string) {
+	var level func(int)
+
+	if show == config.ProgressLightbulb {
+		bulb := hcservice.NewLightbulb()
+		bulb.On.Perms = readOnly
+
+		brightness := characteristic.NewBrightness()
+		brightness.Perms = readOnly
+		bulb.AddCharacteristic(brightness.Characteristic)
+
+		a.AddService(bulb.Service)
+		a.showState(func(state service.State) {
+			bulb.On.SetValue(state == service.StateRunning || state == service.StateStopping)
+		})
+
+		level = brightness.SetValue
+	} else {
+		battery := hcservice.NewBatteryService()
+		battery.ChargingState.SetValue(characteristic.ChargingStateNotCharging)
+
+		a.AddService(battery.Service)
+		a.showState(func(state service.State) {
+			battery.ChargingState.SetValue(boolToInt(state == service.StateStarting || state == service.StateRunning,
+				characteristic.ChargingStateCharging, characteristic.ChargingStateNotCharging))
+		})
+
+		level = battery.BatteryLevel.SetValue
+	}
+
+	a.progress = func(percent float64) {
+		level(int(math.Round(percent)))
+	}
+}
+
+// onInputSelected sets the function called when an input is selected from HomeKit.
+func (a *serviceAccessory) onInputSelected(fn func(input string)) {
+	a.input.OnValueRemoteUpdate(func(id int) {
+		if id >= 1 && id <= len(a.inputs) {
+			fn(a.inputs[id-1])
+		}
+	})
+}
+
+// setInstances shows the number of instances of a running service with replicas. The level is left as it is when
+// the service stops, like HomeKit does with the brightness of lights turned off.
+func (a *serviceAccessory) setInstances(n int) {
+	if a.level != nil && n > 0 {
+		a.level.set(a.replicas.Level(n))
+	}
+}
+
+// setInput shows the given input as selected.
+func (a *serviceAccessory) setInput(input string) {
+	for i, name := range a.inputs {
+		if name == input {
+			a.input.SetValue(i + 1)
+		}
+	}
+}
+
+// stopped presses the exit event button, if any, for a Change of a service that stopped or failed to start: single
+// press when it stopped on its own without errors, long press when it was asked to stop, double press otherwise.
+func (a *serviceAccessory) stopped(change service.Change) {
+	if a.exitEvent == nil {
+		return
+	}
+
+	switch {
+	case change.Requested:
+		a.exitEvent.SetValue(characteristic.ProgrammableSwitchEventLongPress)
+	case change.Err != nil:
+		a.exitEvent.SetValue(characteristic.ProgrammableSwitchEventDoublePress)
+	default:
+		a.exitEvent.SetValue(characteristic.ProgrammableSwitchEventSinglePress)
+	}
+}
+
+// setFault sets StatusFault to show whether the service failed.
+func (a *serviceAccessory) setFault(fault bool) {
+	a.statusFault.SetValue(boolToInt(fault, characteristic.StatusFaultGeneralFault, characteristic.StatusFaultNoFault))
+
+	if a.obstruction != nil {
+		a.obstruction(fault)
+	}
+}
+
+// setState updates the accessory to show the state of the service: power is on while it's starting or running (or
+// off, when inverted), and it's in use while its program runs.
+func (a *serviceAccessory) setState(state service.State) {
+	on := state == service.StateStarting || state == service.StateRunning
+	a.power.set(on != a.inverted)
+
+	if a.inUse != nil {
+		a.inUse(state == service.StateRunning || state == service.StateStopping)
+	}
+
+	if a.current != nil {
+		a.current(state)
+	}
+
+	if a.progress != nil && state == service.StateStarting {
+		a.progress(0)
+	}
+}
+
+// power is the characteristic turning an accessory on and off.
+type power interface {
+	// onRemoteUpdate sets the function called when the accessory is turned on or off from HomeKit.
+	onRemoteUpdate(fn func(on bool))
+
+	set(on bool)
+}
+
+// onPower is an On characteristic.
+type onPower struct {
+	*characteristic.On
+}
+
+func (p onPower) onRemoteUpdate(fn func(on bool)) {
+	p.OnValueRemoteUpdate(fn)
+}
+
+func (p onPower) set(on bool) {
+	p.SetValue(on)
+}
+
+// activePower is an Active characteristic.
+type activePower struct {
+	*characteristic.Active
+}
+
+func (p activePower) onRemoteUpdate(fn func(on bool)) {
+	p.OnValueRemoteUpdate(func(value int) {
+		fn(value == characteristic.ActiveActive)
+	})
+}
+
+func (p activePower) set(on bool) {
+	p.SetValue(boolToInt(on, characteristic.ActiveActive, characteristic.ActiveInactive))
+}
+
+// level is a percentage characteristic, like brightness.
+type level interface {
+	// onRemoteUpdate sets the function called when the level is set from HomeKit.
+	onRemoteUpdate(fn func(level float64))
+
+	set(level float64)
+}
+
+// brightnessLevel is a Brightness characteristic.
+type brightnessLevel struct {
+	*characteristic.Brightness
+}
+
+func (l brightnessLevel) onRemoteUpdate(fn func(level float64)) {
+	l.OnValueRemoteUpdate(func(value int) {
+		fn(float64(value))
+	})
+}
+
+func (l brightnessLevel) set(level float64) {
+	l.SetValue(int(math.Round(level)))
+}
+
+// speedLevel is a Rotation Speed characteristic.
+type speedLevel struct {
+	*characteristic.RotationSpeed
+}
+
+func (l speedLevel) onRemoteUpdate(fn func(level float64)) {
+	l.OnValueRemoteUpdate(fn)
+}
+
+func (l speedLevel) set(level float64) {
+	l.SetValue(math.Round(level))
+}
+
+// targetDoorPower is a Target Door State characteristic, the door being open when the service is on.
+type targetDoorPower struct {
+	*characteristic.TargetDoorState
+}
+
+func (p targetDoorPower) onRemoteUpdate(fn func(on bool)) {
+	p.OnValueRemoteUpdate(func(value int) {
+		fn(value == characteristic.TargetDoorStateOpen)
+	})
+}
+
+func (p targetDoorPower) set(on bool) {
+	p.SetValue(boolToInt(on, characteristic.TargetDoorStateOpen, characteristic.TargetDoorStateClosed))
+}
+
+// targetPositionPower is a Target Position characteristic, the covering being open (at any position) when the
+// service is on.
+type targetPositionPower struct {
+	*characteristic.TargetPosition
+}
+
+func (p targetPositionPower) onRemoteUpdate(fn func(on bool)) {
+	p.OnValueRemoteUpdate(func(value int) {
+		fn(value > 0)
+	})
+}
+
+func (p targetPositionPower) set(on bool) {
+	p.SetValue(boolToInt(on, 100, 0))
+}
+
+// accessoryFactories create the accessories for each value of config.Service.Accessory, but television.
+var accessoryFactories = map[string]func(info accessory.Info) *serviceAccessory{
+	config.AccessorySwitch:         newSwitchAccessory,
+	config.AccessoryOutlet:         newOutletAccessory,
+	config.AccessoryFan:            newFanAccessory,
+	config.AccessoryLightbulb:      newLightbulbAccessory,
+	config.AccessoryValve:          newValveAccessory,
+	config.AccessoryAirPurifier:    newAirPurifierAccessory,
+	config.AccessoryGarageDoor:     newGarageDoorAccessory,
+	config.AccessoryWindowCovering: newWindowCoveringAccessory,
+}
+
+// newServiceAccessory creates the accessory of the type set in svcCfg, a switch when not set.
+func newServiceAccessory(svcCfg config.Service, info accessory.Info) *serviceAccessory {
+	var acc *serviceAccessory
+
+	if factory, ok := accessoryFactories[svcCfg.Accessory]; ok {
+		acc = factory(info)
+	} else if svcCfg.Accessory == config.AccessoryTelevision {
+		acc = newTelevisionAccessory(info, svcCfg.Variants)
+	} else {
+		acc = newSwitchAccessory(info)
+	}
+
+	acc.statusFault = characteristic.NewStatusFault()
+	acc.primary.AddCharacteristic(acc.statusFault.Characteristic)
+
+	acc.statusActive = characteristic.NewStatusActive()
+	acc.statusActive.SetValue(true)
+	acc.primary.AddCharacteristic(acc.statusActive.Characteristic)
+
+	acc.inverted = svcCfg.Invert
+
+	if svcCfg.Replicas != nil {
+		acc.replicas = *svcCfg.Replicas
+
+		if svcCfg.Accessory == config.AccessoryFan {
+			speed := characteristic.NewRotationSpeed()
+			acc.primary.AddCharacteristic(speed.Characteristic)
+			acc.level = speedLevel{speed}
+		} else {
+			brightness := characteristic.NewBrightness()
+			acc.primary.AddCharacteristic(brightness.Characteristic)
+			acc.level = brightnessLevel{brightness}
+		}
+	}
+
+	if svcCfg.Progress != nil {
+		acc.addProgress(svcCfg.Progress.Show)
+	}
+
+	if svcCfg.ExitEvents {
+		button := hcservice.NewStatelessProgrammableSwitch()
+		acc.AddService(button.Service)
+		acc.exitEvent = button.ProgrammableSwitchEvent
+	}
+
+	return acc
+}
+
+func newSwitchAccessory(info accessory.Info) *serviceAccessory {
+	acc := accessory.NewSwitch(info)
+
+	return &serviceAccessory{
+		Accessory: acc.Accessory,
+		power:     onPower{acc.Switch.On},
+		primary:   acc.Switch.Service,
+	}
+}
+
+func newOutletAccessory(info accessory.Info) *serviceAccessory {
+	acc := accessory.NewOutlet(info)
+
+	return &serviceAccessory{
+		Accessory: acc.Accessory,
+		power:     onPower{acc.Outlet.On},
+		inUse:     acc.Outlet.OutletInUse.SetValue,
+		primary:   acc.Outlet.Service,
+	}
+}
+
+func newFanAccessory(info accessory.Info) *serviceAccessory {
+	acc := accessory.New(info, accessory.TypeFan)
+	fan := hcservice.NewFanV2()
+	acc.AddService(fan.Service)
+
+	return &serviceAccessory{Accessory: acc, power: activePower{fan.Active}, primary: fan.Service}
+}
+
+func newLightbulbAccessory(info accessory.Info) *serviceAccessory {
+	acc := accessory.NewLightbulb(info)
+
+	return &serviceAccessory{
+		Accessory: acc.Accessory,
+		power:     onPower{acc.Lightbulb.On},
+		primary:   acc.Lightbulb.Service,
+	}
+}
+
+func newValveAccessory(info accessory.Info) *serviceAccessory {
+	acc := accessory.New(info, accessory.TypeFaucets)
+	valve := hcservice.NewValve()
+	valve.ValveType.SetValue(characteristic.ValveTypeGenericValve)
+	acc.AddService(valve.Service)
+
+	return &serviceAccessory{
+		Accessory: acc,
+		power:     activePower{valve.Active},
+		inUse: func(running bool) {
+			valve.InUse.SetValue(boolToInt(running, characteristic.InUseInUse, characteristic.InUseNotInUse))
+		},
+		primary: valve.Service,
+	}
+}
+
+func newAirPurifierAccessory(info accessory.Info) *serviceAccessory {
+	acc := accessory.New(info, accessory.TypeAirPurifier)
+	purifier := hcservice.NewAirPurifier()
+	purifier.TargetAirPurifierState.SetValue(characteristic.TargetAirPurifierStateManual)
+	acc.AddService(purifier.Service)
+
+	return &serviceAccessory{
+		Accessory: acc,
+		power:     activePower{purifier.Active},
+		inUse: func(running bool) {
+			purifier.CurrentAirPurifierState.SetValue(boolToInt(running,
+				characteristic.CurrentAirPurifierStatePurifyingAir, characteristic.CurrentAirPurifierStateInactive))
+		},
+		primary: purifier.Service,
+	}
+}
+
+// newGarageDoorAccessory creates a garage door, open while the service is running, opening while it starts and
+// closing while it stops. It's obstructed when the service fails.
+func newGarageDoorAccessory(info accessory.Info) *serviceAccessory {
+	acc := accessory.New(info, accessory.TypeGarageDoorOpener)
+	door := hcservice.NewGarageDoorOpener()
+	door.CurrentDoorState.SetValue(characteristic.CurrentDoorStateClosed)
+	door.TargetDoorState.SetValue(characteristic.TargetDoorStateClosed)
+	acc.AddService(door.Service)
+
+	return &serviceAccessory{
+		Accessory: acc,
+		power:     targetDoorPower{door.TargetDoorState},
+		current: func(state service.State) {
+			door.CurrentDoorState.SetValue(doorStates[state])
+		},
+		obstruction: door.ObstructionDetected.SetValue,
+		primary:     door.Service,
+	}
+}
+
+var doorStates = map[service.State]int{
+	service.StateStopped:  characteristic.CurrentDoorStateClosed,
+	service.StateStarting: characteristic.CurrentDoorStateOpening,
+	service.StateRunning:  characteristic.CurrentDoorStateOpen,
+	service.StateStopping: characteristic.CurrentDoorStateClosing,
+}
+
+// newWindowCoveringAccessory creates a window covering, fully open while the service is running, going up while it
+// starts and down while it stops. It's obstructed when the service fails.
+func newWindowCoveringAccessory(info accessory.Info) *serviceAccessory {
+	acc := accessory.New(info, accessory.TypeWindowCovering)
+	covering := hcservice.NewWindowCovering()
+	covering.PositionState.SetValue(characteristic.PositionStateStopped)
+
+	obstruction := characteristic.NewObstructionDetected()
+	covering.AddCharacteristic(obstruction.Characteristic)
+	acc.AddService(covering.Service)
+
+	return &serviceAccessory{
+		Accessory: acc,
+		power:     targetPositionPower{covering.TargetPosition},
+		current: func(state service.State) {
+			switch state {
+			case service.StateStarting:
+				covering.CurrentPosition.SetValue(0)
+				covering.PositionState.SetValue(characteristic.PositionStateIncreasing)
+			case service.StateRunning:
+				covering.CurrentPosition.SetValue(100)
+				covering.PositionState.SetValue(characteristic.PositionStateStopped)
+			case service.StateStopping:
+				covering.CurrentPosition.SetValue(100)
+				covering.PositionState.SetValue(characteristic.PositionStateDecreasing)
+			default:
+				covering.CurrentPosition.SetValue(0)
+				covering.PositionState.SetValue(characteristic.PositionStateStopped)
+			}
+		},
+		obstruction: obstruction.SetValue,
+		primary:     covering.Service,
+	}
+}
+
+// newTelevisionAccessory creates a television whose inputs are the variants of the service: it's active while the
+// service is running, and selecting an input runs that variant.
+func newTelevisionAccessory(info accessory.Info, variants []config.Variant) *serviceAccessory {
+	acc := accessory.New(info, accessory.TypeTelevision)
+	tv := hcservice.NewTelevision()
+	tv.ConfiguredName.SetValue(info.Name)
+	tv.SleepDiscoveryMode.SetValue(characteristic.SleepDiscoveryModeAlwaysDiscoverable)
+	tv.ActiveIdentifier.SetValue(1)
+	acc.AddService(tv.Service)
+
+	var inputs []string
+
+	for i, v := range variants {
+		input := hcservice.NewInputSource()
+		input.Identifier.SetValue(i + 1)
+		input.ConfiguredName.SetValue(v.Name)
+		input.Name.SetValue(v.Name)
+		input.InputSourceType.SetValue(characteristic.InputSourceTypeApplication)
+		input.IsConfigured.SetValue(characteristic.IsConfiguredConfigured)
+		input.CurrentVisibilityState.SetValue(characteristic.CurrentVisibilityStateShown)
+
+		acc.AddService(input.Service)
+		tv.AddLinkedService(input.Service)
+
+		inputs = append(inputs, v.Name)
+	}
+
+	return &serviceAccessory{
+		Accessory: acc,
+		power:     activePower{tv.Active},
+		primary:   tv.Service,
+		input:     tv.ActiveIdentifier,
+		inputs:    inputs,
+	}
+}
+
+func boolToInt(b bool, ifTrue, ifFalse int) int {
+	if b {
+		return ifTrue
+	}
+
+	return ifFalse
+}
@@ -0,0 +1,159 @@
+package homekit
+
+import (
+	"github.com/brutella/hc/accessory"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeHandle struct {
+	done chan struct{}
+}
+
+func (h *fakeHandle) Wait() error {
+	<-h.done
+	return nil
+}
+
+func (h *fakeHandle) Stop() {
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+}
+
+type fakeService struct {
+	name string
+}
+
+func (s *fakeService) Start() (service.Handle, error) {
+	return &fakeHandle{done: make(chan struct{})}, nil
+}
+
+func (s *fakeService) Name() string {
+	return s.name
+}
+
+func (s *fakeService) String() string {
+	return s.name
+}
+
+// turn turns the accessory's switch on or off as HomeKit does.
+func turn(t *testing.T, acc *serviceAccessory, on bool) {
+	t.Helper()
+
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+
+	acc.power.(onPower).UpdateValueFromConnection(on, conn)
+}
+
+func isOn(acc *serviceAccessory) bool {
+	return acc.power.(onPower).GetValue()
+}
+
+func waitState(t *testing.T, mgr *service.Manager, svc service.Service, state service.State) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for mgr.State(svc) != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("is = %v, want = %v", mgr.State(svc), state)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServiceAccessory_SetState(t *testing.T) {
+	tests := []struct {
+		state    service.State
+		inverted bool
+		want     bool
+	}{
+		{state: service.StateStopped, want: false},
+		{state: service.StateStarting, want: true},
+		{state: service.StateRunning, want: true},
+		{state: service.StateStopping, want: false},
+		{state: service.StateStopped, inverted: true, want: true},
+		{state: service.StateStarting, inverted: true, want: false},
+		{state: service.StateRunning, inverted: true, want: false},
+		{state: service.StateStopping, inverted: true, want: true},
+	}
+
+	for _, tt := range tests {
+		svcCfg := config.Service{Name: "test", Invert: tt.inverted}
+		acc := newServiceAccessory(svcCfg, accessory.Info{Name: svcCfg.Name})
+
+		// from the opposite value, so that setting it is seen
+		acc.power.set(!tt.want)
+		acc.setState(tt.state)
+
+		if is, want := isOn(acc), tt.want; is != want {
+			t.Fatalf("%v, inverted %v: is = %v, want = %v", tt.state, tt.inverted, is, want)
+		}
+	}
+}
+
+func TestBridge_StartStopServicesBySwitch_Inverted(t *testing.T) {
+	mgr := service.NewManager()
+	defer mgr.Shutdown()
+
+	b := &Bridge{mgr: mgr}
+	svc := &fakeService{name: "test"}
+	svcCfg := config.Service{Name: "test", Invert: true}
+	acc := newServiceAccessory(svcCfg, accessory.Info{Name: svcCfg.Name})
+
+	b.startStopServicesBySwitch([]service.Service{svc}, []config.Service{svcCfg}, []*serviceAccessory{acc})
+
+	// on while stopped
+	acc.setState(service.StateStopped)
+
+	turn(t, acc, false)
+	waitState(t, mgr, svc, service.StateRunning)
+	acc.setState(service.StateRunning)
+
+	turn(t, acc, true)
+	waitState(t, mgr, svc, service.StateStopped)
+}
+
+func TestBridge_StartStopServicesBySwitch_ReadOnly(t *testing.T) {
+	mgr := service.NewManager()
+	defer mgr.Shutdown()
+
+	b := &Bridge{mgr: mgr}
+	svc := &fakeService{name: "test"}
+	svcCfg := config.Service{Name: "test", Control: config.ControlReadOnly}
+	acc := newServiceAccessory(svcCfg, accessory.Info{Name: svcCfg.Name})
+
+	b.startStopServicesBySwitch([]service.Service{svc}, []config.Service{svcCfg}, []*serviceAccessory{acc})
+
+	turn(t, acc, true)
+
+	if is, want := isOn(acc), false; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is, want := mgr.State(svc), service.StateStopped; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	mgr.Start(svc)
+	waitState(t, mgr, svc, service.StateRunning)
+	acc.setState(service.StateRunning)
+
+	turn(t, acc, false)
+
+	if is, want := isOn(acc), true; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is, want := mgr.State(svc), service.StateRunning; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
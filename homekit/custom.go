@@ -0,0 +1,442 @@
+package homekit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+	hcservice "github.com/brutella/hc/service"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// valueEnv is the environment variable passing the new value of a characteristic to its set command.
+const valueEnv = "HKSWITCH_VALUE"
+
+// customServiceTypes are the HAP service types of config.CustomService.Type.
+var customServiceTypes = map[string]string{
+	"switch":                  hcservice.TypeSwitch,
+	"outlet":                  hcservice.TypeOutlet,
+	"lightbulb":               hcservice.TypeLightbulb,
+	"fan":                     hcservice.TypeFanV2,
+	"thermostat":              hcservice.TypeThermostat,
+	"heater-cooler":           hcservice.TypeHeaterCooler,
+	"humidifier-dehumidifier": hcservice.TypeHumidifierDehumidifier,
+	"air-purifier":            hcservice.TypeAirPurifier,
+	"window-covering":         hcservice.TypeWindowCovering,
+	"window":                  hcservice.TypeWindow,
+	"door":                    hcservice.TypeDoor,
+	"garage-door-opener":      hcservice.TypeGarageDoorOpener,
+	"lock-mechanism":          hcservice.TypeLockMechanism,
+	"valve":                   hcservice.TypeValve,
+	"security-system":         hcservice.TypeSecuritySystem,
+	"temperature-sensor":      hcservice.TypeTemperatureSensor,
+	"humidity-sensor":         hcservice.TypeHumiditySensor,
+	"light-sensor":            hcservice.TypeLightSensor,
+	"contact-sensor":          hcservice.TypeContactSensor,
+	"motion-sensor":           hcservice.TypeMotionSensor,
+	"occupancy-sensor":        hcservice.TypeOccupancySensor,
+	"leak-sensor":             hcservice.TypeLeakSensor,
+	"battery":                 hcservice.TypeBatteryService,
+}
+
+// customCharacteristicTypes create the characteristics of config.CustomCharacteristic.Type.
+var customCharacteristicTypes = map[string]func() *characteristic.Characteristic{
+	"on": func() *characteristic.Characteristic {
+		return characteristic.NewOn().Characteristic
+	},
+	"active": func() *characteristic.Characteristic {
+		return characteristic.NewActive().Characteristic
+	},
+	"in-use": func() *characteristic.Characteristic {
+		return characteristic.NewInUse().Characteristic
+	},
+	"brightness": func() *characteristic.Characteristic {
+		return characteristic.NewBrightness().Characteristic
+	},
+	"hue": func() *characteristic.Characteristic {
+		return characteristic.NewHue().Characteristic
+	},
+	"saturation": func() *characteristic.Characteristic {
+		return characteristic.NewSaturation().Characteristic
+	},
+	"color-temperature": func() *characteristic.Characteristic {
+		return characteristic.NewColorTemperature().Characteristic
+	},
+	"rotation-speed": func() *characteristic.Characteristic {
+		return characteristic.NewRotationSpeed().Characteristic
+	},
+	"current-temperature": func() *characteristic.Characteristic {
+		return characteristic.NewCurrentTemperature().Characteristic
+	},
+	"target-temperature": func() *characteristic.Characteristic {
+		return characteristic.NewTargetTemperature().Characteristic
+	},
+	"temperature-display-units": func() *characteristic.Characteristic {
+		return characteristic.NewTemperatureDisplayUnits().Characteristic
+	},
+	"current-heating-cooling-state": func() *characteristic.Characteristic {
+		return characteristic.NewCurrentHeatingCoolingState().Characteristic
+	},
+	"target-heating-cooling-state": func() *characteristic.Characteristic {
+		return characteristic.NewTargetHeatingCoolingState().Characteristic
+	},
+	"heating-threshold-temperature": func() *characteristic.Characteristic {
+		return characteristic.NewHeatingThresholdTemperature().Characteristic
+	},
+	"cooling-threshold-temperature": func() *characteristic.Characteristic {
+		return characteristic.NewCoolingThresholdTemperature().Characteristic
+	},
+	"current-heater-cooler-state": func() *characteristic.Characteristic {
+		return characteristic.NewCurrentHeaterCoolerState().Characteristic
+	},
+	"target-heater-cooler-state": func() *characteristic.Characteristic {
+		return characteristic.NewTargetHeaterCoolerState().Characteristic
+	},
+	"current-relative-humidity": func() *characteristic.Characteristic {
+		return characteristic.NewCurrentRelativeHumidity().Characteristic
+	},
+	"target-relative-humidity": func() *characteristic.Characteristic {
+		return characteristic.NewTargetRelativeHumidity().Characteristic
+	},
+	"current-position": func() *characteristic.Characteristic {
+		return characteristic.NewCurrentPosition().Characteristic
+	},
+	"target-position": func() *characteristic.Characteristic {
+		return characteristic.NewTargetPosition().Characteristic
+	},
+	"position-state": func() *characteristic.Characteristic {
+		return characteristic.NewPositionState().Characteristic
+	},
+	"hold-position": func() *characteristic.Characteristic {
+		return characteristic.NewHoldPosition().Characteristic
+	},
+	"current-door-state": func() *characteristic.Characteristic {
+		return characteristic.NewCurrentDoorState().Characteristic
+	},
+	"target-door-state": func() *characteristic.Characteristic {
+		return characteristic.NewTargetDoorState().Characteristic
+	},
+	"obstruction-detected": func() *characteristic.Characteristic {
+		return characteristic.NewObstructionDetected().Characteristic
+	},
+	"lock-current-state": func() *characteristic.Characteristic {
+		return characteristic.NewLockCurrentState().Characteristic
+	},
+	"lock-target-state": func() *characteristic.Characteristic {
+		return characteristic.NewLockTargetState().Characteristic
+	},
+	"security-system-current-state": func() *characteristic.Characteristic {
+		return characteristic.NewSecuritySystemCurrentState().Characteristic
+	},
+	"security-system-target-state": func() *characteristic.Characteristic {
+		return characteristic.NewSecuritySystemTargetState().Characteristic
+	},
+	"contact-sensor-state": func() *characteristic.Characteristic {
+		return characteristic.NewContactSensorState().Characteristic
+	},
+	"motion-detected": func() *characteristic.Characteristic {
+		return characteristic.NewMotionDetected().Characteristic
+	},
+	"occupancy-detected": func() *characteristic.Characteristic {
+		return characteristic.NewOccupancyDetected().Characteristic
+	},
+	"leak-detected": func() *characteristic.Characteristic {
+		return characteristic.NewLeakDetected().Characteristic
+	},
+	"current-ambient-light-level": func() *characteristic.Characteristic {
+		return characteristic.NewCurrentAmbientLightLevel().Characteristic
+	},
+	"battery-level": func() *characteristic.Characteristic {
+		return characteristic.NewBatteryLevel().Characteristic
+	},
+	"charging-state": func() *characteristic.Characteristic {
+		return characteristic.NewChargingState().Characteristic
+	},
+	"status-low-battery": func() *characteristic.Characteristic {
+		return characteristic.NewStatusLowBattery().Characteristic
+	},
+	"status-fault": func() *characteristic.Characteristic {
+		return characteristic.NewStatusFault().Characteristic
+	},
+}
+
+// customAccessory is the accessory of a config.CustomAccessory.
+type customAccessory struct {
+	*accessory.Accessory
+
+	characteristics []*commandCharacteristic
+	interval        time.Duration
+}
+
+func newCustomAccessory(cfg config.CustomAccessory, info accessory.Info) (*customAccessory, error) {
+	a := &customAccessory{
+		Accessory: accessory.New(info, accessory.TypeOther),
+		interval:  cfg.PollInterval(),
+	}
+
+	for _, svcCfg := range cfg.Services {
+		typ, ok := customServiceTypes[svcCfg.Type]
+		if !ok {
+			return nil, fmt.Errorf("accessory %s: invalid service type %q", cfg.Name, svcCfg.Type)
+		}
+
+		svc := hcservice.New(typ)
+
+		for _, charCfg := range svcCfg.Characteristics {
+			newChar, ok := customCharacteristicTypes[charCfg.Type]
+			if !ok {
+				return nil, fmt.Errorf("accessory %s: invalid characteristic type %q", cfg.Name, charCfg.Type)
+			}
+
+			c := newCommandCharacteristic(cfg, charCfg, newChar())
+			svc.AddCharacteristic(c.Characteristic)
+			a.characteristics = append(a.characteristics, c)
+		}
+
+		a.AddService(svc)
+	}
+
+	return a, nil
+}
+
+// run reads the characteristics every interval, and serves reads and writes from HomeKit, until ctx is done.
+func (a *customAccessory) run(ctx context.Context) {
+	for _, c := range a.characteristics {
+		c.ctx = ctx
+	}
+
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			for _, c := range a.characteristics {
+				c.refresh()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// commandCharacteristic is a characteristic whose value is read by its get command and written by its set command.
+// The commands run in the background, so that reads and writes from HomeKit never wait for them: a read returns the
+// last value, running the get command if that value is older than the cache duration, and a write runs the set
+// command after the previous one has finished, skipping values written in the meanwhile but the last.
+type commandCharacteristic struct {
+	*characteristic.Characteristic
+
+	name    string
+	get     *service.Command
+	set     *service.Command
+	timeout time.Duration
+	cache   time.Duration
+
+	// ctx is done when the bridge stops.
+	ctx context.Context
+
+	mu         sync.Mutex
+	readAt     time.Time
+	reading    bool
+	writing    bool
+	pending    interface{}
+	hasPending bool
+}
+
+func newCommandCharacteristic(accCfg config.CustomAccessory, cfg config.CustomCharacteristic,
+	c *characteristic.Characteristic) *commandCharacteristic {
+	cc := &commandCharacteristic{
+		Characteristic: c,
+		name:           fmt.Sprintf("accessory %s: %s", accCfg.Name, cfg.Type),
+		timeout:        accCfg.CommandTimeout(),
+		cache:          accCfg.CacheDuration(),
+		ctx:            context.Background(),
+	}
+
+	if len(cfg.Get) > 0 {
+		cc.get = &service.Command{Path: cfg.Get[0], Args: cfg.Get[1:], Workdir: accCfg.Workdir, Env: accCfg.Env}
+
+		c.OnValueGet(func() interface{} {
+			cc.refreshIfStale()
+			return c.Value
+		})
+	}
+
+	if len(cfg.Set) > 0 {
+		cc.set = &service.Command{Path: cfg.Set[0], Args: cfg.Set[1:], Workdir: accCfg.Workdir, Env: accCfg.Env}
+
+		c.OnValueUpdateFromConn(func(_ net.Conn, _ *characteristic.Characteristic, newValue, _ interface{}) {
+			cc.write(newValue)
+		})
+	}
+
+	return cc
+}
+
+// refreshIfStale runs the get command in the background if the value is older than the cache duration.
+func (c *commandCharacteristic) refreshIfStale() {
+	c.mu.Lock()
+	stale := time.Since(c.readAt) > c.cache
+	c.mu.Unlock()
+
+	if stale {
+		go c.refresh()
+	}
+}
+
+// refresh runs the get command, unless it's already running, and updates the value with its output.
+func (c *commandCharacteristic) refresh() {
+	if c.get == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.reading {
+		c.mu.Unlock()
+		return
+	}
+
+	c.reading = true
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.reading = false
+		c.mu.Unlock()
+	}()
+
+	output, err := c.run(c.get, nil)
+	if err == nil {
+		var value interface{}
+		if value, err = parseValue(c.Format, output); err == nil {
+			c.UpdateValue(value)
+
+			c.mu.Lock()
+			c.readAt = time.Now()
+			c.mu.Unlock()
+		}
+	}
+
+	if err != nil {
+		log.Info.Printf("%s: get: %s", c.name, err)
+	}
+}
+
+// write runs the set command with value in the background, after the one already running, if any.
+func (c *commandCharacteristic) write(value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending = value
+	c.hasPending = true
+
+	if c.writing {
+		return
+	}
+
+	c.writing = true
+
+	go func() {
+		for {
+			c.mu.Lock()
+			if !c.hasPending {
+				c.writing = false
+				c.mu.Unlock()
+				return
+			}
+
+			value := c.pending
+			c.hasPending = false
+			c.mu.Unlock()
+
+			if _, err := c.run(c.set, value); err != nil {
+				log.Info.Printf("%s: set %v: %s", c.name, value, err)
+
+				// show the actual value again
+				c.refresh()
+				continue
+			}
+
+			c.mu.Lock()
+			c.readAt = time.Now()
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// run runs cmd with the timeout, passing it value, when not nil, as last argument and in its environment. It returns
+// the output, trimmed.
+func (c *commandCharacteristic) run(cmd *service.Command, value interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+
+	if value != nil {
+		arg := formatValue(value)
+
+		withValue := *cmd
+		withValue.Args = append(append([]string{}, cmd.Args...), arg)
+		withValue.Env = append(append([]string{}, cmd.Env...), valueEnv+"="+arg)
+		cmd = &withValue
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	if err := cmd.Run(ctx, stdout, stderr); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// parseValue parses the output of a get command according to the characteristic's format.
+func parseValue(format string, output string) (interface{}, error) {
+	switch format {
+	case characteristic.FormatBool:
+		var value bool
+		err := setBool(output, func(b bool) { value = b })
+		return value, err
+	case characteristic.FormatFloat:
+		value, err := strconv.ParseFloat(output, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: not a number", output)
+		}
+
+		return value, nil
+	case characteristic.FormatUInt8, characteristic.FormatUInt16, characteristic.FormatUInt32,
+		characteristic.FormatUInt64, characteristic.FormatInt32:
+		value, err := strconv.Atoi(output)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: not an integer", output)
+		}
+
+		return value, nil
+	default:
+		return output, nil
+	}
+}
+
+// formatValue formats a value written by HomeKit for a set command.
+func formatValue(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,202 @@
+package homekit
+
+import (
+	"github.com/brutella/hc/characteristic"
+	"io/ioutil"
+	"mrz.io/hkswitch/app/config"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// eventually waits for cond to be true, failing the test after a few seconds.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("is = %v, want = %v", false, true)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		format string
+		output string
+		want   interface{}
+		err    bool
+	}{
+		{format: characteristic.FormatBool, output: "true", want: true},
+		{format: characteristic.FormatBool, output: "Off", want: false},
+		{format: characteristic.FormatBool, output: "1", want: true},
+		{format: characteristic.FormatBool, output: "maybe", err: true},
+		{format: characteristic.FormatFloat, output: "21.5", want: 21.5},
+		{format: characteristic.FormatFloat, output: "-3", want: -3.0},
+		{format: characteristic.FormatFloat, output: "warm", err: true},
+		{format: characteristic.FormatUInt8, output: "2", want: 2},
+		{format: characteristic.FormatUInt32, output: "4000", want: 4000},
+		{format: characteristic.FormatInt32, output: "-1", want: -1},
+		{format: characteristic.FormatUInt8, output: "2.5", err: true},
+		{format: characteristic.FormatString, output: "hello", want: "hello"},
+	}
+
+	for _, tt := range tests {
+		value, err := parseValue(tt.format, tt.output)
+
+		if tt.err {
+			if err == nil {
+				t.Fatalf("%s %q: is = %v, want an error", tt.format, tt.output, value)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s %q: is = %v, want = %v", tt.format, tt.output, err, nil)
+		}
+
+		if is, want := value, tt.want; !reflect.DeepEqual(is, want) {
+			t.Fatalf("%s %q: is = %#v, want = %#v", tt.format, tt.output, is, want)
+		}
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: true, want: "true"},
+		{value: 21.5, want: "21.5"},
+		{value: 20.0, want: "20"},
+		{value: 0.1, want: "0.1"},
+		{value: 3, want: "3"},
+		{value: "on", want: "on"},
+	}
+
+	for _, tt := range tests {
+		if is, want := formatValue(tt.value), tt.want; is != want {
+			t.Fatalf("%#v: is = %q, want = %q", tt.value, is, want)
+		}
+	}
+}
+
+func TestCommandCharacteristic_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "written")
+
+	accCfg := config.CustomAccessory{Name: "test"}
+	charCfg := config.CustomCharacteristic{
+		Type: "target-temperature",
+		Set:  []string{"bash", "-c", `echo "$HKSWITCH_VALUE $1" >> ` + path + `; sleep 0.3`, "set"},
+	}
+
+	c := newCommandCharacteristic(accCfg, charCfg, characteristic.NewTargetTemperature().Characteristic)
+
+	c.write(20.0)
+
+	eventually(t, func() bool {
+		return readFile(t, path) != ""
+	})
+
+	// written while the first set command runs: only the last one is
+	for _, value := range []float64{21, 22, 23.5} {
+		c.write(value)
+	}
+
+	eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return !c.writing
+	})
+
+	if is, want := readFile(t, path), "20 20\n23.5 23.5\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestCommandCharacteristic_Get(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reads")
+
+	// prints the number of times it ran
+	accCfg := config.CustomAccessory{Name: "test", Cache: time.Hour}
+	charCfg := config.CustomCharacteristic{
+		Type: "current-temperature",
+		Get:  []string{"bash", "-c", "echo >> " + path + "; wc -l < " + path},
+	}
+
+	c := newCommandCharacteristic(accCfg, charCfg, characteristic.NewCurrentTemperature().Characteristic)
+
+	c.refresh()
+
+	if is, want := c.Value, interface{}(1.0); is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	// cached: a read from HomeKit returns the value without running the command
+	if is, want := c.GetValue(), interface{}(1.0); is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if is, want := readFile(t, path), "\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	// stale: the read still returns the last value, and runs the command in the background
+	c.mu.Lock()
+	c.readAt = time.Now().Add(-2 * time.Hour)
+	c.mu.Unlock()
+
+	if is, want := c.GetValue(), interface{}(1.0); is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	// not read again until the command is done, as the characteristic's value isn't safe for concurrent use
+	eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		return !c.reading && readFile(t, path) == "\n\n"
+	})
+
+	if is, want := c.Value, interface{}(2.0); is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestCommandCharacteristic_GetFailed(t *testing.T) {
+	accCfg := config.CustomAccessory{Name: "test"}
+	charCfg := config.CustomCharacteristic{Type: "current-temperature", Get: []string{"echo", "warm"}}
+
+	c := newCommandCharacteristic(accCfg, charCfg, characteristic.NewCurrentTemperature().Characteristic)
+	c.UpdateValue(18.0)
+
+	c.refresh()
+
+	// the last value is kept, and read again at the next read
+	if is, want := c.Value, interface{}(18.0); is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is, want := c.readAt.IsZero(), true; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
@@ -0,0 +1,127 @@
+package homekit
+
+import (
+	"context"
+	"fmt"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+	"time"
+)
+
+// group is the switch of a config.Group, starting and stopping its services together.
+type group struct {
+	*accessory.Accessory
+
+	on *characteristic.On
+
+	cfg      config.Group
+	mgr      *service.Manager
+	services []service.Service
+	// replicas are the replicas of the group's services that have them.
+	replicas map[service.Service]config.Replicas
+}
+
+func newGroup(cfg config.Group, info accessory.Info, mgr *service.Manager, byName map[string]service.Service,
+	svcCfgs map[string]config.Service) (*group, error) {
+	acc := accessory.NewSwitch(info)
+	g := &group{Accessory: acc.Accessory, on: acc.Switch.On, cfg: cfg, mgr: mgr,
+		replicas: make(map[service.Service]config.Replicas)}
+
+	for _, name := range cfg.Services {
+		svc, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("group %s: unknown service %q", cfg.Name, name)
+		}
+
+		g.services = append(g.services, svc)
+
+		if replicas := svcCfgs[name].Replicas; replicas != nil {
+			g.replicas[svc] = *replicas
+		}
+	}
+
+	g.on.OnValueRemoteUpdate(func(on bool) {
+		if on {
+			g.start()
+		} else {
+			g.mgr.Stop(g.services...)
+		}
+	})
+
+	return g, nil
+}
+
+// start starts the group's services that aren't running, with the lowest number of instances for the ones with
+// replicas, as their own switches do.
+func (g *group) start() {
+	var services []service.Service
+
+	for _, svc := range g.services {
+		replicas, ok := g.replicas[svc]
+		if !ok {
+			services = append(services, svc)
+			continue
+		}
+
+		if g.mgr.Instances(svc) == 0 {
+			g.mgr.Scale(replicas.Lowest(), svc)
+		}
+	}
+
+	g.mgr.Start(services...)
+}
+
+// run keeps the switch in sync with the state of the group's services until ctx is done.
+func (g *group) run(ctx context.Context) {
+	members := make(map[service.Service]bool)
+	for _, svc := range g.services {
+		members[svc] = true
+	}
+
+	// subscribed before syncing, like in Bridge.updateSwitchByServiceState
+	changes := g.mgr.SubscribeTransitions(ctx)
+
+	go func() {
+		g.sync()
+
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case change, ok := <-changes:
+				if !ok {
+					return
+				}
+
+				if members[change.Service] {
+					g.sync()
+				}
+			case <-ticker.C:
+				g.sync()
+			}
+		}
+	}()
+}
+
+// sync turns the switch on when all of the group's services are starting or running, or any of them with
+// config.GroupStateAny, and off otherwise.
+func (g *group) sync() {
+	var count int
+
+	for _, svc := range g.services {
+		if state := g.mgr.State(svc); state == service.StateStarting || state == service.StateRunning {
+			count++
+		}
+	}
+
+	if g.cfg.State == config.GroupStateAny {
+		g.on.SetValue(count > 0)
+	} else {
+		g.on.SetValue(count == len(g.services))
+	}
+}
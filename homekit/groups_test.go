@@ -0,0 +1,53 @@
+package homekit
+
+import (
+	"github.com/brutella/hc/accessory"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGroup_Start(t *testing.T) {
+	mgr := service.NewManager()
+	defer mgr.Shutdown()
+
+	plain := &fakeService{name: "plain"}
+	replicated := &fakeService{name: "replicated"}
+
+	cfg := config.Group{Name: "group", Services: []string{"plain", "replicated"}}
+	byName := map[string]service.Service{"plain": plain, "replicated": replicated}
+	svcCfgs := map[string]config.Service{
+		"plain":      {Name: "plain"},
+		"replicated": {Name: "replicated", Replicas: &config.Replicas{Min: 2, Max: 4}},
+	}
+
+	g, err := newGroup(cfg, accessory.Info{Name: cfg.Name}, mgr, byName, svcCfgs)
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+
+	g.on.UpdateValueFromConnection(true, conn)
+
+	waitState(t, mgr, plain, service.StateRunning)
+	waitState(t, mgr, replicated, service.StateRunning)
+
+	// scaled to the lowest number of instances, as from the service's own switch
+	deadline := time.Now().Add(5 * time.Second)
+	for mgr.Instances(replicated) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if is, want := mgr.Instances(replicated), 2; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is, want := mgr.Instances(plain), 1; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
@@ -1,14 +1,20 @@
 package homekit
 
 import (
+	"context"
 	"errors"
 	"github.com/brutella/hc"
 	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/log"
 	"mrz.io/hkswitch/app/config"
 	"mrz.io/hkswitch/service"
 	"time"
 )
 
+// reconcileInterval is how often the switches are synced with the state of all services, in case a Change was
+// missed.
+const reconcileInterval = time.Minute
+
 // Bridge exposes Services to HomeKit.
 type Bridge struct {
 	cfg config.Config
@@ -18,26 +24,44 @@ type Bridge struct {
 	transport hc.Transport
 
 	startStopCh chan bool
-	startErr    error
-	didStopCh   chan error
+
+	// done is closed when the transport stops, after err is set.
+	done chan struct{}
+	err  error
+
+	// ctx is canceled when done is closed, to stop the goroutines tied to the bridge.
+	ctx context.Context
+
+	// byName are the accessories of the services, by service name.
+	byName map[string]*serviceAccessory
 }
 
-// NewBridge creates a new Bridge that uses the service.Manager to control the given services. A nil Bridge and error
-// can be returned if initializing the underlying hc.Transport fails.
+// NewBridge creates a new Bridge that uses the service.Manager to control the given services, showing those named
+// in cfg.Bridge.Services (see config.Config.BridgeConfigs); groups can start and stop any of them. A nil Bridge and
+// error can be returned if initializing the underlying hc.Transport fails.
 func NewBridge(cfg config.Config, mgr *service.Manager, services ...service.Service) (*Bridge, error) {
 	b := &Bridge{}
 
 	b.cfg = cfg
 	b.mgr = mgr
-	b.didStopCh = make(chan error)
+	b.done = make(chan struct{})
 	b.startStopCh = make(chan bool)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	b.ctx = ctx
+
 	t, err := b.initializeTransport(services)
 
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
+	go func() {
+		<-b.done
+		cancel()
+	}()
+
 	b.transport = t
 
 	go func() {
@@ -48,10 +72,17 @@ func NewBridge(cfg config.Config, mgr *service.Manager, services ...service.Serv
 			select {
 			case err := <-didStopCh:
 				started = false
-				b.didStopCh <- err
+				b.err = err
+				close(b.done)
 				return
 			case newState := <-b.startStopCh:
 				if newState == started {
+					if !started {
+						// stopped before starting
+						close(b.done)
+						return
+					}
+
 					continue
 				}
 
@@ -72,12 +103,12 @@ func NewBridge(cfg config.Config, mgr *service.Manager, services ...service.Serv
 // Start starts the bridge (including underlying transport), blocking until a call to Stop.
 func (b *Bridge) Start() error {
 	select {
-	case <-b.startStopCh:
-		return nil
-	default:
-		b.startStopCh <- true
-		return <-b.didStopCh
+	case <-b.done:
+	case b.startStopCh <- true:
+		<-b.done
 	}
+
+	return b.err
 }
 
 func (b *Bridge) startTransport() <-chan error {
@@ -96,13 +127,19 @@ func (b *Bridge) startTransport() <-chan error {
 	return didStopCh
 }
 
-// Stop stops the bridge and the underlying transport, blocking until a call to Stop.
+// Stop stops the bridge and the underlying transport, blocking until the transport has stopped.
 func (b *Bridge) Stop() {
 	select {
-	case <-b.startStopCh:
-	default:
-		b.startStopCh <- false
-		<-b.didStopCh
+	case <-b.done:
+	case b.startStopCh <- false:
+		<-b.done
+	}
+}
+
+// SetProgress shows the progress of the job of the named service, if its accessory shows progress.
+func (b *Bridge) SetProgress(svc string, percent float64) {
+	if acc, ok := b.byName[svc]; ok && acc.progress != nil {
+		acc.progress(percent)
 	}
 }
 
@@ -112,7 +149,7 @@ func (b *Bridge) stopTransport() {
 	}()
 }
 
-func (b *Bridge) initializeTransport(services []service.Service) (hc.Transport, error) {
+func (b *Bridge) initializeTransport(all []service.Service) (hc.Transport, error) {
 	bridgeInfo := accessory.Info{
 		Name:             b.cfg.Name,
 		Manufacturer:     b.cfg.Manufacturer,
@@ -122,7 +159,57 @@ func (b *Bridge) initializeTransport(services []service.Service) (hc.Transport,
 	}
 	bridge := accessory.NewBridge(bridgeInfo)
 
-	switches, accessories := b.createSwitches(services)
+	storageDir := b.cfg.StoragePath()
+
+	services := b.bridgeServices(all)
+	svcCfgs := b.serviceConfigs(services)
+
+	keys := append(serviceKeys(svcCfgs), sensorKeys(b.cfg.Sensors)...)
+	keys = append(keys, customAccessoryKeys(b.cfg.Accessories)...)
+	keys = append(keys, groupKeys(b.cfg.Groups)...)
+
+	ids, err := assignAccessoryIDs(storageDir, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	switches, accessories := b.createAccessories(svcCfgs, ids)
+
+	b.byName = make(map[string]*serviceAccessory)
+	for i, svc := range services {
+		b.byName[svc.Name()] = switches[i]
+	}
+
+	ids = ids[len(svcCfgs):]
+
+	sensors, err := b.createSensors(ids[:len(b.cfg.Sensors)])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range sensors {
+		accessories = append(accessories, s.Accessory)
+	}
+
+	ids = ids[len(b.cfg.Sensors):]
+
+	customs, err := b.createCustomAccessories(ids[:len(b.cfg.Accessories)])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range customs {
+		accessories = append(accessories, a.Accessory)
+	}
+
+	groups, err := b.createGroups(ids[len(b.cfg.Accessories):], all)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, g := range groups {
+		accessories = append(accessories, g.Accessory)
+	}
 
 	transportConfig := hc.Config{Pin: b.cfg.Pin, Port: b.cfg.Port, StoragePath: b.cfg.StorageDir}
 	t, err := hc.NewIPTransport(transportConfig, bridge.Accessory, accessories...)
@@ -130,49 +217,303 @@ func (b *Bridge) initializeTransport(services []service.Service) (hc.Transport,
 		return nil, err
 	}
 
-	b.startStopServicesBySwitch(services, switches)
+	b.startStopServicesBySwitch(services, svcCfgs, switches)
 	b.updateSwitchByServiceState(switches, services)
+	b.identifyBridgeByCommand(bridge.Accessory)
+	b.identifyServicesByCommand(services, svcCfgs, switches)
+
+	for _, s := range sensors {
+		s.run(b.ctx)
+	}
+
+	for _, a := range customs {
+		a.run(b.ctx)
+	}
+
+	for _, g := range groups {
+		g.run(b.ctx)
+	}
 
 	return t, nil
 }
 
-func (b *Bridge) createSwitches(services []service.Service) ([]*accessory.Switch, []*accessory.Accessory) {
-	var switches []*accessory.Switch
+func (b *Bridge) createCustomAccessories(ids []uint64) ([]*customAccessory, error) {
+	var list []*customAccessory
+
+	for i, accCfg := range b.cfg.Accessories {
+		a, err := newCustomAccessory(accCfg, accessory.Info{Name: accCfg.Name, ID: ids[i]})
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, a)
+	}
+
+	return list, nil
+}
+
+func (b *Bridge) createGroups(ids []uint64, services []service.Service) ([]*group, error) {
+	byName := make(map[string]service.Service)
+	for _, svc := range services {
+		byName[svc.Name()] = svc
+	}
+
+	svcCfgs := make(map[string]config.Service)
+	for _, svcCfg := range b.cfg.Services {
+		svcCfgs[svcCfg.Name] = svcCfg
+	}
+
+	var groups []*group
+
+	for i, groupCfg := range b.cfg.Groups {
+		g, err := newGroup(groupCfg, accessory.Info{Name: groupCfg.Name, ID: ids[i]}, b.mgr, byName, svcCfgs)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
+
+func (b *Bridge) createSensors(ids []uint64) ([]*sensor, error) {
+	var sensors []*sensor
+
+	for i, sensorCfg := range b.cfg.Sensors {
+		s, err := newSensor(sensorCfg, accessory.Info{Name: sensorCfg.Name, ID: ids[i]})
+		if err != nil {
+			return nil, err
+		}
+
+		sensors = append(sensors, s)
+	}
+
+	return sensors, nil
+}
+
+// bridgeServices returns the services shown by the bridge, in the order they are given.
+func (b *Bridge) bridgeServices(services []service.Service) []service.Service {
+	names := make(map[string]bool)
+	for _, name := range b.cfg.Bridge.Services {
+		names[name] = true
+	}
+
+	var list []service.Service
+	for _, svc := range services {
+		if names[svc.Name()] {
+			list = append(list, svc)
+		}
+	}
+
+	return list
+}
+
+// serviceConfigs returns the configuration of each service, found by name.
+func (b *Bridge) serviceConfigs(services []service.Service) []config.Service {
+	byName := make(map[string]config.Service)
+	for _, svcCfg := range b.cfg.Services {
+		byName[svcCfg.Name] = svcCfg
+	}
+
+	list := make([]config.Service, 0, len(services))
+	for _, svc := range services {
+		svcCfg, ok := byName[svc.Name()]
+		if !ok {
+			svcCfg = config.Service{Name: svc.Name()}
+		}
+
+		list = append(list, svcCfg)
+	}
+
+	return list
+}
+
+func (b *Bridge) createAccessories(svcCfgs []config.Service, ids []uint64) ([]*serviceAccessory,
+	[]*accessory.Accessory) {
+	var switches []*serviceAccessory
 	var accessories []*accessory.Accessory
 
-	for _, s := range services {
-		info := accessory.Info{Name: s.Name()}
-		switchAcc := accessory.NewSwitch(info)
+	for i, svcCfg := range svcCfgs {
+		info := accessory.Info{Name: svcCfg.Name, ID: ids[i]}
+		acc := newServiceAccessory(svcCfg, info)
 
-		switches = append(switches, switchAcc)
-		accessories = append(accessories, switchAcc.Accessory)
+		switches = append(switches, acc)
+		accessories = append(accessories, acc.Accessory)
 	}
 
 	return switches, accessories
 }
 
-func (b *Bridge) startStopServicesBySwitch(services []service.Service, switches []*accessory.Switch) {
+func (b *Bridge) startStopServicesBySwitch(services []service.Service, svcCfgs []config.Service,
+	switches []*serviceAccessory) {
 	for i, svc := range services {
 		svc := svc
 		acc := switches[i]
 
-		acc.Switch.On.OnValueRemoteUpdate(func(on bool) {
-			if on {
-				b.mgr.Start(svc)
+		if svcCfgs[i].Control == config.ControlReadOnly {
+			b.revertRemoteUpdates(svc, acc)
+			continue
+		}
+
+		acc.power.onRemoteUpdate(func(on bool) {
+			if on != acc.inverted {
+				b.start(svc, acc)
 			} else {
 				b.mgr.Stop(svc)
 			}
 		})
+
+		if acc.level != nil {
+			acc.level.onRemoteUpdate(func(level float64) {
+				if n := acc.replicas.Count(level); n > 0 {
+					b.mgr.Scale(n, svc)
+				} else {
+					b.mgr.Stop(svc)
+				}
+			})
+		}
+
+		if selector, ok := svc.(service.Selector); ok && acc.input != nil {
+			acc.setInput(selector.Selected())
+			acc.onInputSelected(func(input string) {
+				b.selectVariant(svc, selector, input)
+			})
+		}
+	}
+}
+
+// revertRemoteUpdates makes the accessory of a read-only service show the state of the service again when it's
+// changed from HomeKit, without starting or stopping it.
+func (b *Bridge) revertRemoteUpdates(svc service.Service, acc *serviceAccessory) {
+	revert := func() {
+		log.Info.Printf("%s is read-only, ignoring change from HomeKit", svc.Name())
+		acc.setState(b.mgr.State(svc))
+		acc.setInstances(b.mgr.Instances(svc))
+	}
+
+	acc.power.onRemoteUpdate(func(bool) { revert() })
+
+	if acc.level != nil {
+		acc.level.onRemoteUpdate(func(float64) { revert() })
+	}
+
+	if selector, ok := svc.(service.Selector); ok && acc.input != nil {
+		acc.setInput(selector.Selected())
+		acc.onInputSelected(func(string) {
+			revert()
+			acc.setInput(selector.Selected())
+		})
 	}
 }
 
-func (b *Bridge) updateSwitchByServiceState(switches []*accessory.Switch, services []service.Service) {
+// faulted returns whether a service failed, given whether it had failed and the state it was in before change: any
+// of its instances failing to start or stopping with an error without being asked to fails it, until it's started
+// again from stopped or asked to stop.
+func faulted(failed bool, previous service.State, change service.Change) bool {
+	switch {
+	case change.Err != nil && !change.Requested:
+		return true
+	case change.State == service.StateStopped && change.Requested:
+		return false
+	case previous == service.StateStopped && change.State != service.StateStopped:
+		return false
+	}
+
+	return failed
+}
+
+// start starts the service if it's not running, with the lowest number of instances for a service with replicas.
+func (b *Bridge) start(svc service.Service, acc *serviceAccessory) {
+	if acc.level == nil {
+		b.mgr.Start(svc)
+		return
+	}
+
+	if b.mgr.Instances(svc) == 0 {
+		b.mgr.Scale(acc.replicas.Lowest(), svc)
+	}
+}
+
+// selectVariant selects the variant of the service, restarting it to run that variant unless it's stopped: a service
+// starting is restarted once it runs, and one stopping is only started again if it was restarting.
+func (b *Bridge) selectVariant(svc service.Service, selector service.Selector, variant string) {
+	if variant == selector.Selected() || !selector.Select(variant) {
+		return
+	}
+
+	log.Info.Printf("%s: selected %s", svc.Name(), variant)
+
+	if b.mgr.State(svc) != service.StateStopped {
+		b.mgr.Restart(svc)
+	}
+}
+
+// updateSwitchByServiceState syncs the switches with the state of the services, and then updates them as the
+// services start and stop, and while they are starting and stopping, until the bridge stops. StatusFault is set when
+// any instance of a service fails to start or stops with an error without being asked to, and cleared when the
+// service is started again or asked to stop. All switches are synced again every reconcileInterval, in case a Change
+// was missed. The returned channel is closed once the switches aren't updated anymore.
+func (b *Bridge) updateSwitchByServiceState(switches []*serviceAccessory, services []service.Service) <-chan struct{} {
+	bySvc := make(map[service.Service]*serviceAccessory)
+	for i, svc := range services {
+		bySvc[svc] = switches[i]
+	}
+
+	// the state of each service, and whether it failed, only touched by the goroutine below
+	states := make(map[service.Service]service.State)
+	faults := make(map[service.Service]bool)
+
+	// subscribe before syncing, so that no Change happening in the meanwhile is missed
+	changes := b.mgr.SubscribeTransitions(b.ctx)
+
+	sync := func() {
+		for i, acc := range switches {
+			states[services[i]] = b.mgr.State(services[i])
+
+			acc.setState(states[services[i]])
+			acc.setInstances(b.mgr.Instances(services[i]))
+		}
+	}
+
+	done := make(chan struct{})
+
 	go func() {
+		defer close(done)
+
+		sync()
+
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+
 		for {
-			<-time.After(1 * time.Second)
-			for i, acc := range switches {
-				acc.Switch.On.SetValue(b.mgr.Running(services[i]))
+			select {
+			case <-b.ctx.Done():
+				return
+			case change, ok := <-changes:
+				if !ok {
+					return
+				}
+
+				if acc, ok := bySvc[change.Service]; ok {
+					stopped := change.State == service.StateStopped
+
+					faults[change.Service] = faulted(faults[change.Service], states[change.Service], change)
+					states[change.Service] = change.State
+
+					acc.setState(change.State)
+					acc.setInstances(change.Instances)
+					acc.setFault(faults[change.Service])
+
+					if stopped {
+						acc.stopped(change)
+					}
+				}
+			case <-ticker.C:
+				sync()
 			}
 		}
 	}()
+
+	return done
 }
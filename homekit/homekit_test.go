@@ -0,0 +1,312 @@
+package homekit
+
+import (
+	"context"
+	"errors"
+	"github.com/brutella/hc/accessory"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowHandle is a fakeHandle taking a while to stop, so that the service is seen stopping.
+type slowHandle struct {
+	fakeHandle
+	once sync.Once
+}
+
+func (h *slowHandle) Stop() {
+	h.once.Do(func() {
+		time.AfterFunc(50*time.Millisecond, func() {
+			close(h.done)
+		})
+	})
+}
+
+type slowService struct {
+	fakeService
+}
+
+func (s *slowService) Start() (service.Handle, error) {
+	return &slowHandle{fakeHandle: fakeHandle{done: make(chan struct{})}}, nil
+}
+
+// watchStates returns a switch for svc and the channel receiving each state it shows.
+func watchStates(svc service.Service) (*serviceAccessory, <-chan service.State) {
+	states := make(chan service.State, 100)
+
+	acc := newServiceAccessory(config.Service{Name: svc.Name()}, accessory.Info{Name: svc.Name()})
+	acc.showState(func(state service.State) {
+		states <- state
+	})
+
+	return acc, states
+}
+
+// nextState returns the next state shown, failing the test if none is shown for a few seconds.
+func nextState(t *testing.T, states <-chan service.State) service.State {
+	t.Helper()
+
+	select {
+	case state := <-states:
+		return state
+	case <-time.After(5 * time.Second):
+		t.Fatalf("is = %v, want = %v", "no state", "a state")
+		return service.StateStopped
+	}
+}
+
+func TestBridge_UpdateSwitchByServiceState_Initial(t *testing.T) {
+	mgr := service.NewManager()
+	defer mgr.Shutdown()
+
+	running := &fakeService{name: "running"}
+	stopped := &fakeService{name: "stopped"}
+
+	mgr.Start(running)
+	waitState(t, mgr, running, service.StateRunning)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &Bridge{mgr: mgr, ctx: ctx}
+
+	runningAcc, runningStates := watchStates(running)
+	stoppedAcc, stoppedStates := watchStates(stopped)
+
+	b.updateSwitchByServiceState([]*serviceAccessory{runningAcc, stoppedAcc}, []service.Service{running, stopped})
+
+	if is, want := nextState(t, runningStates), service.StateRunning; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is, want := nextState(t, stoppedStates), service.StateStopped; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestBridge_UpdateSwitchByServiceState_Changes(t *testing.T) {
+	mgr := service.NewManager()
+	defer mgr.Shutdown()
+
+	svc := &slowService{fakeService{name: "slow"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b := &Bridge{mgr: mgr, ctx: ctx}
+
+	acc, states := watchStates(svc)
+	b.updateSwitchByServiceState([]*serviceAccessory{acc}, []service.Service{svc})
+
+	if is, want := nextState(t, states), service.StateStopped; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	mgr.Start(svc)
+
+	for _, want := range []service.State{service.StateStarting, service.StateRunning} {
+		if is := nextState(t, states); is != want {
+			t.Fatalf("is = %v, want = %v", is, want)
+		}
+	}
+
+	mgr.Stop(svc)
+
+	for _, want := range []service.State{service.StateStopping, service.StateStopped} {
+		if is := nextState(t, states); is != want {
+			t.Fatalf("is = %v, want = %v", is, want)
+		}
+	}
+}
+
+func TestBridge_UpdateSwitchByServiceState_Done(t *testing.T) {
+	mgr := service.NewManager()
+	defer mgr.Shutdown()
+
+	svc := &fakeService{name: "test"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Bridge{mgr: mgr, ctx: ctx}
+
+	acc, states := watchStates(svc)
+	done := b.updateSwitchByServiceState([]*serviceAccessory{acc}, []service.Service{svc})
+
+	nextState(t, states)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("is = %v, want = %v", "updating", "done")
+	}
+
+	// not updated anymore
+	mgr.Start(svc)
+	waitState(t, mgr, svc, service.StateRunning)
+
+	select {
+	case state := <-states:
+		t.Fatalf("is = %v, want = %v", state, "no state")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFaulted(t *testing.T) {
+	crashed := errors.New("exit status 1")
+	signaled := errors.New("signal: terminated")
+
+	tests := []struct {
+		name     string
+		failed   bool
+		previous service.State
+		change   service.Change
+		want     bool
+	}{
+		{
+			name:     "started",
+			previous: service.StateStopped,
+			change:   service.Change{State: service.StateStarting, Running: true, Instances: 1},
+			want:     false,
+		},
+		{
+			name:     "failed to start",
+			previous: service.StateStarting,
+			change:   service.Change{State: service.StateStopped, Err: crashed},
+			want:     true,
+		},
+		{
+			name:     "one instance crashed, others still running",
+			previous: service.StateRunning,
+			change: service.Change{State: service.StateRunning, Running: true, Err: crashed, Instances: 2,
+				Scaled: true},
+			want: true,
+		},
+		{
+			name:     "still running after an instance crashed",
+			failed:   true,
+			previous: service.StateRunning,
+			change:   service.Change{State: service.StateRunning, Running: true, Instances: 3, Scaled: true},
+			want:     true,
+		},
+		{
+			name:     "last instance exited cleanly after another crashed",
+			failed:   true,
+			previous: service.StateRunning,
+			change:   service.Change{State: service.StateStopped},
+			want:     true,
+		},
+		{
+			name:     "scaled down",
+			previous: service.StateRunning,
+			change: service.Change{State: service.StateRunning, Running: true, Err: signaled, Requested: true,
+				Instances: 1, Scaled: true},
+			want: false,
+		},
+		{
+			name:     "asked to stop",
+			failed:   true,
+			previous: service.StateStopping,
+			change:   service.Change{State: service.StateStopped, Err: signaled, Requested: true},
+			want:     false,
+		},
+		{
+			name:     "started again",
+			failed:   true,
+			previous: service.StateStopped,
+			change:   service.Change{State: service.StateStarting, Running: true, Instances: 1},
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if is, want := faulted(tt.failed, tt.previous, tt.change), tt.want; is != want {
+				t.Fatalf("is = %v, want = %v", is, want)
+			}
+		})
+	}
+}
+
+// variantService is a slowService recording the variant run by each instance it starts.
+type variantService struct {
+	slowService
+
+	mu       sync.Mutex
+	selected string
+	started  []string
+}
+
+func (s *variantService) Start() (service.Handle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.started = append(s.started, s.selected)
+	return s.slowService.Start()
+}
+
+func (s *variantService) Variants() []string {
+	return []string{"a", "b", "c"}
+}
+
+func (s *variantService) Selected() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.selected
+}
+
+func (s *variantService) Select(variant string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.selected = variant
+	return true
+}
+
+func (s *variantService) startedVariants() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.started...)
+}
+
+func TestBridge_SelectVariant(t *testing.T) {
+	mgr := service.NewManager()
+	defer mgr.Shutdown()
+
+	svc := &variantService{slowService: slowService{fakeService{name: "tv"}}, selected: "a"}
+	b := &Bridge{mgr: mgr, ctx: context.Background()}
+
+	// stopped: selected for the next start
+	b.selectVariant(svc, svc, "b")
+
+	mgr.Start(svc)
+	eventually(t, func() bool {
+		return mgr.State(svc) == service.StateRunning
+	})
+
+	// running: restarted once stopped
+	b.selectVariant(svc, svc, "c")
+
+	eventually(t, func() bool {
+		return len(svc.startedVariants()) == 2 && mgr.State(svc) == service.StateRunning
+	})
+
+	// stopping: stays stopped
+	mgr.Stop(svc)
+	b.selectVariant(svc, svc, "a")
+
+	eventually(t, func() bool {
+		return mgr.State(svc) == service.StateStopped
+	})
+
+	time.Sleep(100 * time.Millisecond)
+
+	if is, want := svc.startedVariants(), []string{"b", "c"}; !reflect.DeepEqual(is, want) {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
@@ -0,0 +1,68 @@
+package homekit
+
+import (
+	"context"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/log"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+	"time"
+)
+
+// identifyTimeout is how long an identify command can run before it's killed.
+const identifyTimeout = 10 * time.Second
+
+// identifyBridgeByCommand runs the bridge's identify command, if any, when the bridge is identified, its output going
+// to hkswitch's messages.
+func (b *Bridge) identifyBridgeByCommand(bridge *accessory.Accessory) {
+	if len(b.cfg.IdentifyCommand) == 0 {
+		return
+	}
+
+	cmd := &service.Command{Path: b.cfg.IdentifyCommand[0], Args: b.cfg.IdentifyCommand[1:]}
+
+	bridge.OnIdentify(func() {
+		go b.identify(b.cfg.Name, func(ctx context.Context) error {
+			return cmd.Run(ctx, log.Info.Writer(), log.Info.Writer())
+		})
+	})
+}
+
+// identifyServicesByCommand runs the identify command of each service, if any, when its accessory is identified, its
+// output going where the service's goes.
+func (b *Bridge) identifyServicesByCommand(services []service.Service, svcCfgs []config.Service,
+	switches []*serviceAccessory) {
+	for i, svc := range services {
+		svcCfg := svcCfgs[i]
+
+		runner, ok := svc.(service.CommandRunner)
+		if !ok || len(svcCfg.IdentifyCommand) == 0 {
+			continue
+		}
+
+		cmd := &service.Command{
+			Path:    svcCfg.IdentifyCommand[0],
+			Args:    svcCfg.IdentifyCommand[1:],
+			Workdir: svcCfg.Workdir,
+			Env:     svcCfg.Env,
+		}
+
+		switches[i].OnIdentify(func() {
+			go b.identify(svcCfg.Name, func(ctx context.Context) error {
+				return runner.RunCommand(ctx, cmd)
+			})
+		})
+	}
+}
+
+// identify runs an identify command with the timeout, logging when it fails.
+func (b *Bridge) identify(name string, run func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(b.ctx, identifyTimeout)
+	defer cancel()
+
+	log.Info.Printf("identify %s", name)
+
+	if err := run(ctx); err != nil {
+		log.Info.Printf("identify %s: %s", name, err)
+	}
+}
@@ -0,0 +1,61 @@
+package homekit
+
+import (
+	"context"
+	"github.com/brutella/hc/accessory"
+	"io/ioutil"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+	"path/filepath"
+	"testing"
+)
+
+func TestBridge_IdentifyBridgeByCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identified")
+
+	cfg := config.Config{Bridge: config.Bridge{
+		Name:            "bridge",
+		IdentifyCommand: []string{"bash", "-c", "echo beep > " + path},
+	}}
+
+	b := &Bridge{cfg: cfg, ctx: context.Background()}
+	bridge := accessory.NewBridge(accessory.Info{Name: cfg.Name})
+
+	b.identifyBridgeByCommand(bridge.Accessory)
+	bridge.Identify()
+
+	eventually(t, func() bool {
+		return readFile(t, path) == "beep\n"
+	})
+}
+
+func TestBridge_IdentifyServicesByCommand(t *testing.T) {
+	dir := t.TempDir()
+
+	// run in the service's work dir and environment
+	svcCfgs := []config.Service{
+		{Name: "identified", Workdir: dir, Env: []string{"X=1"},
+			IdentifyCommand: []string{"bash", "-c", "echo blink $X > identified"}},
+		{Name: "other"},
+	}
+
+	var services []service.Service
+	var switches []*serviceAccessory
+
+	for _, svcCfg := range svcCfgs {
+		cmd := &service.Command{Path: "true"}
+		services = append(services, service.NewDaemon(svcCfg.Name, cmd, ioutil.Discard, ioutil.Discard))
+		switches = append(switches, newServiceAccessory(svcCfg, accessory.Info{Name: svcCfg.Name}))
+	}
+
+	b := &Bridge{ctx: context.Background()}
+	b.identifyServicesByCommand(services, svcCfgs, switches)
+
+	// without an identify command, identifying does nothing
+	switches[1].Identify()
+	switches[0].Identify()
+
+	eventually(t, func() bool {
+		return readFile(t, filepath.Join(dir, "identified")) == "blink 1\n"
+	})
+}
@@ -0,0 +1,267 @@
+package homekit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/brutella/hc/log"
+	"io/ioutil"
+	"mrz.io/hkswitch/app/config"
+	"os"
+	"path/filepath"
+)
+
+// accessoryIDsFile is the file, in the bridge's storage dir, recording the ID of the accessory of each service.
+const accessoryIDsFile = "accessories.json"
+
+// firstAccessoryID is the ID of the first accessory after the bridge, whose ID is 1.
+const firstAccessoryID = 2
+
+// accessoryEntry records the ID of the accessory of the service identified by Key, which is the service's id or,
+// when it has none, its name.
+type accessoryEntry struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	ID   uint64 `json:"id"`
+
+	// Removed is set when the service is not configured anymore. The entry is kept so that its ID is not given to
+	// another service, and is given back to the service if it's configured again.
+	Removed bool `json:"removed,omitempty"`
+}
+
+// accessoryKey identifies an accessory to give an ID to.
+type accessoryKey struct {
+	Key  string
+	Name string
+}
+
+// serviceKeys returns the accessoryKeys of services.
+func serviceKeys(services []config.Service) []accessoryKey {
+	keys := make([]accessoryKey, 0, len(services))
+	for _, svc := range services {
+		keys = append(keys, accessoryKey{Key: svc.Key(), Name: svc.Name})
+	}
+
+	return keys
+}
+
+// sensorKeys returns the accessoryKeys of sensors, which are distinct from the ones of services with the same name.
+func sensorKeys(sensors []config.Sensor) []accessoryKey {
+	keys := make([]accessoryKey, 0, len(sensors))
+	for _, sensor := range sensors {
+		keys = append(keys, accessoryKey{Key: "sensor:" + sensor.Key(), Name: sensor.Name})
+	}
+
+	return keys
+}
+
+// customAccessoryKeys returns the accessoryKeys of the accessories backed by commands.
+func customAccessoryKeys(accessories []config.CustomAccessory) []accessoryKey {
+	keys := make([]accessoryKey, 0, len(accessories))
+	for _, acc := range accessories {
+		keys = append(keys, accessoryKey{Key: "accessory:" + acc.Key(), Name: acc.Name})
+	}
+
+	return keys
+}
+
+// groupKeys returns the accessoryKeys of the groups of services.
+func groupKeys(groups []config.Group) []accessoryKey {
+	keys := make([]accessoryKey, 0, len(groups))
+	for _, group := range groups {
+		keys = append(keys, accessoryKey{Key: "group:" + group.Key(), Name: group.Name})
+	}
+
+	return keys
+}
+
+// assignAccessoryIDs returns the IDs of the accessories identified by services, in order. The IDs are the ones
+// recorded in dir by previous runs, and new accessories get new IDs, so that accessories keep their identity in
+// HomeKit whatever the order of services. Renamed, removed and reordered services are logged.
+func assignAccessoryIDs(dir string, services []accessoryKey) ([]uint64, error) {
+	path := filepath.Join(dir, accessoryIDsFile)
+
+	entries, err := readAccessoryIDs(path)
+	if os.IsNotExist(err) {
+		entries, err = seedAccessoryIDs(dir, services)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("accessory IDs: %w", err)
+	}
+
+	byKey := make(map[string]int)
+	var previous []string
+	var maxID uint64 = firstAccessoryID - 1
+
+	for i, e := range entries {
+		byKey[e.Key] = i
+
+		if !e.Removed {
+			previous = append(previous, e.Key)
+		}
+
+		if e.ID > maxID {
+			maxID = e.ID
+		}
+	}
+
+	ids := make([]uint64, 0, len(services))
+	configured := make(map[string]bool)
+	var current, added []string
+
+	for _, svc := range services {
+		key := svc.Key
+		configured[key] = true
+		current = append(current, key)
+
+		i, ok := byKey[key]
+		if !ok {
+			maxID++
+			i = len(entries)
+			entries = append(entries, accessoryEntry{Key: key, Name: svc.Name, ID: maxID})
+			byKey[key] = i
+			added = append(added, svc.Name)
+		}
+
+		e := &entries[i]
+
+		if e.Removed {
+			log.Info.Printf("accessories: %s is configured again, it gets its previous accessory back", svc.Name)
+			e.Removed = false
+		}
+
+		if e.Name != svc.Name {
+			log.Info.Printf("accessories: %s was renamed to %s, it keeps its accessory", e.Name, svc.Name)
+			e.Name = svc.Name
+		}
+
+		ids = append(ids, e.ID)
+	}
+
+	var removed []string
+	for i := range entries {
+		e := &entries[i]
+		if !configured[e.Key] && !e.Removed {
+			log.Info.Printf("accessories: %s is not configured anymore, its accessory is removed", e.Name)
+			e.Removed = true
+			removed = append(removed, e.Name)
+		}
+	}
+
+	if len(added) > 0 && len(removed) > 0 {
+		log.Info.Printf("accessories: %q have new accessories, and %q were removed: set `id` to the old name of a "+
+			"renamed service to keep its accessory", added, removed)
+	}
+
+	if reordered(previous, current) {
+		log.Info.Printf("accessories: services were reordered, their accessories are not affected")
+	}
+
+	// recorded in the order of services, so that reorders are detected against the last one
+	ordered := make([]accessoryEntry, 0, len(entries))
+	for _, key := range current {
+		ordered = append(ordered, entries[byKey[key]])
+	}
+
+	for _, e := range entries {
+		if e.Removed {
+			ordered = append(ordered, e)
+		}
+	}
+
+	if err := writeAccessoryIDs(path, ordered); err != nil {
+		return nil, fmt.Errorf("accessory IDs: %w", err)
+	}
+
+	return ids, nil
+}
+
+// seedAccessoryIDs returns the entries to start from when dir has no accessories file. When the bridge was already
+// paired, accessories got IDs by the position of their service, so the same IDs are given to the services in their
+// current order, assuming it didn't change since.
+func seedAccessoryIDs(dir string, services []accessoryKey) ([]accessoryEntry, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	log.Info.Printf("accessories: giving accessories the IDs they had by the order of services")
+
+	var entries []accessoryEntry
+	for i, svc := range services {
+		entries = append(entries, accessoryEntry{Key: svc.Key, Name: svc.Name, ID: uint64(firstAccessoryID + i)})
+	}
+
+	return entries, nil
+}
+
+// reordered returns true if the keys found in both previous and current are not in the same order.
+func reordered(previous, current []string) bool {
+	inPrevious := make(map[string]bool)
+	for _, key := range previous {
+		inPrevious[key] = true
+	}
+
+	inCurrent := make(map[string]bool)
+	for _, key := range current {
+		inCurrent[key] = true
+	}
+
+	var a, b []string
+	for _, key := range previous {
+		if inCurrent[key] {
+			a = append(a, key)
+		}
+	}
+
+	for _, key := range current {
+		if inPrevious[key] {
+			b = append(b, key)
+		}
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func readAccessoryIDs(path string) ([]accessoryEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []accessoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// writeAccessoryIDs writes entries to path, replacing it in whole.
+func writeAccessoryIDs(path string, entries []accessoryEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
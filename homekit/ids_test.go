@@ -0,0 +1,204 @@
+package homekit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func keysOf(names ...string) []accessoryKey {
+	keys := make([]accessoryKey, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, accessoryKey{Key: name, Name: name})
+	}
+
+	return keys
+}
+
+func TestAssignAccessoryIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		// runs are the services configured by each run, the IDs of the last one are checked
+		runs [][]string
+		want []uint64
+	}{
+		{
+			name: "first run",
+			runs: [][]string{{"a", "b", "c"}},
+			want: []uint64{2, 3, 4},
+		},
+		{
+			name: "same order",
+			runs: [][]string{{"a", "b", "c"}, {"a", "b", "c"}},
+			want: []uint64{2, 3, 4},
+		},
+		{
+			name: "reordered",
+			runs: [][]string{{"a", "b", "c"}, {"c", "a", "b"}},
+			want: []uint64{4, 2, 3},
+		},
+		{
+			name: "added in the middle",
+			runs: [][]string{{"a", "b"}, {"a", "c", "b"}},
+			want: []uint64{2, 4, 3},
+		},
+		{
+			name: "removed",
+			runs: [][]string{{"a", "b", "c"}, {"a", "c"}},
+			want: []uint64{2, 4},
+		},
+		{
+			name: "removed ID not reused",
+			runs: [][]string{{"a", "b"}, {"a"}, {"a", "c"}},
+			want: []uint64{2, 4},
+		},
+		{
+			name: "configured again",
+			runs: [][]string{{"a", "b"}, {"a"}, {"b", "a"}},
+			want: []uint64{3, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			var ids []uint64
+			for _, run := range tt.runs {
+				var err error
+				ids, err = assignAccessoryIDs(dir, keysOf(run...))
+				if err != nil {
+					t.Fatalf("is = %v, want = %v", err, nil)
+				}
+			}
+
+			if is, want := ids, tt.want; !reflect.DeepEqual(is, want) {
+				t.Fatalf("is = %v, want = %v", is, want)
+			}
+		})
+	}
+}
+
+func TestAssignAccessoryIDs_Renamed(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := assignAccessoryIDs(dir, []accessoryKey{{Key: "a", Name: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// renamed, with id set to the old name
+	ids, err := assignAccessoryIDs(dir, []accessoryKey{{Key: "a", Name: "renamed"}})
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	if is, want := ids, []uint64{2}; !reflect.DeepEqual(is, want) {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	entries, err := readAccessoryIDs(filepath.Join(dir, accessoryIDsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if is, want := entries, []accessoryEntry{{Key: "a", Name: "renamed", ID: 2}}; !reflect.DeepEqual(is, want) {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestAssignAccessoryIDs_Seed(t *testing.T) {
+	dir := t.TempDir()
+
+	// a bridge paired before the accessories file existed
+	if err := ioutil.WriteFile(filepath.Join(dir, "keypair"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := assignAccessoryIDs(dir, keysOf("a", "b", "c"))
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	if is, want := ids, []uint64{2, 3, 4}; !reflect.DeepEqual(is, want) {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	ids, err = assignAccessoryIDs(dir, keysOf("b", "c", "a"))
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	if is, want := ids, []uint64{3, 4, 2}; !reflect.DeepEqual(is, want) {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestAssignAccessoryIDs_Existing(t *testing.T) {
+	dir := t.TempDir()
+
+	data := `[
+  {"key": "b", "name": "b", "id": 7},
+  {"key": "a", "name": "a", "id": 3},
+  {"key": "old", "name": "old", "id": 9, "removed": true}
+]`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, accessoryIDsFile), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := assignAccessoryIDs(dir, keysOf("a", "b", "new", "old"))
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	if is, want := ids, []uint64{3, 7, 10, 9}; !reflect.DeepEqual(is, want) {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestAssignAccessoryIDs_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, accessoryIDsFile)
+
+	if err := ioutil.WriteFile(path, []byte(`[{"key": "a",`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := assignAccessoryIDs(dir, keysOf("a")); err == nil {
+		t.Fatalf("is = %v, want an error", err)
+	}
+
+	// left as it is, so that it can be fixed by hand
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if is, want := string(data), `[{"key": "a",`; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestReordered(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous []string
+		current  []string
+		want     bool
+	}{
+		{name: "same", previous: []string{"a", "b"}, current: []string{"a", "b"}, want: false},
+		{name: "swapped", previous: []string{"a", "b"}, current: []string{"b", "a"}, want: true},
+		{name: "added", previous: []string{"a", "b"}, current: []string{"a", "c", "b"}, want: false},
+		{name: "removed", previous: []string{"a", "b", "c"}, current: []string{"a", "c"}, want: false},
+		{name: "first run", previous: nil, current: []string{"a", "b"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if is, want := reordered(tt.previous, tt.current), tt.want; is != want {
+				t.Fatalf("is = %v, want = %v", is, want)
+			}
+		})
+	}
+}
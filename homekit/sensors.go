@@ -0,0 +1,193 @@
+package homekit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"github.com/brutella/hc/log"
+	hcservice "github.com/brutella/hc/service"
+	"mrz.io/hkswitch/app/config"
+	"mrz.io/hkswitch/service"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sensor is the accessory of a config.Sensor, updated with the output of its probe command.
+type sensor struct {
+	*accessory.Accessory
+
+	cfg config.Sensor
+	cmd *service.Command
+
+	// update sets the sensor's characteristic from the probe's output, returning an error if it can't be parsed.
+	update func(output string) error
+
+	statusFault *characteristic.StatusFault
+	fault       bool
+}
+
+// sensorFactories create the sensor accessories for each value of config.Sensor.Type. They return the sensor's
+// service and the function updating it.
+var sensorFactories = map[string]func() (*hcservice.Service, func(output string) error){
+	config.SensorTemperature: newTemperatureSensor,
+	config.SensorHumidity:    newHumiditySensor,
+	config.SensorLight:       newLightSensor,
+	config.SensorContact:     newContactSensor,
+	config.SensorOccupancy:   newOccupancySensor,
+	config.SensorMotion:      newMotionSensor,
+}
+
+func newSensor(cfg config.Sensor, info accessory.Info) (*sensor, error) {
+	factory, ok := sensorFactories[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("sensor %s: invalid type %q", cfg.Name, cfg.Type)
+	}
+
+	svc, update := factory()
+
+	s := &sensor{
+		Accessory: accessory.New(info, accessory.TypeSensor),
+		cfg:       cfg,
+		cmd: &service.Command{
+			Path:    cfg.Command[0],
+			Args:    cfg.Command[1:],
+			Workdir: cfg.Workdir,
+			Env:     cfg.Env,
+		},
+		update:      update,
+		statusFault: characteristic.NewStatusFault(),
+	}
+
+	svc.AddCharacteristic(s.statusFault.Characteristic)
+	s.AddService(svc)
+
+	return s, nil
+}
+
+// run probes the sensor every interval until ctx is done.
+func (s *sensor) run(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.cfg.ProbeInterval())
+		defer ticker.Stop()
+
+		for {
+			s.probe(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// probe runs the probe command, updating the sensor with its output, or setting StatusFault if the command fails or
+// its output can't be parsed. Failures are logged when StatusFault is set, and when it's cleared.
+func (s *sensor) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.ProbeTimeout())
+	defer cancel()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	err := s.cmd.Run(ctx, stdout, stderr)
+	if err == nil {
+		err = s.update(strings.TrimSpace(stdout.String()))
+	} else if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		err = fmt.Errorf("%w: %s", err, msg)
+	}
+
+	if err != nil && !s.fault {
+		log.Info.Printf("sensor %s: probe failed: %s", s.cfg.Name, err)
+	}
+
+	if err == nil && s.fault {
+		log.Info.Printf("sensor %s: probe succeeded", s.cfg.Name)
+	}
+
+	s.fault = err != nil
+	s.statusFault.SetValue(boolToInt(s.fault, characteristic.StatusFaultGeneralFault, characteristic.StatusFaultNoFault))
+}
+
+func newTemperatureSensor() (*hcservice.Service, func(string) error) {
+	svc := hcservice.NewTemperatureSensor()
+	svc.CurrentTemperature.SetMinValue(-270)
+
+	return svc.Service, func(output string) error {
+		return setFloat(output, svc.CurrentTemperature.SetValue)
+	}
+}
+
+func newHumiditySensor() (*hcservice.Service, func(string) error) {
+	svc := hcservice.NewHumiditySensor()
+
+	return svc.Service, func(output string) error {
+		return setFloat(output, svc.CurrentRelativeHumidity.SetValue)
+	}
+}
+
+func newLightSensor() (*hcservice.Service, func(string) error) {
+	svc := hcservice.NewLightSensor()
+
+	return svc.Service, func(output string) error {
+		return setFloat(output, svc.CurrentAmbientLightLevel.SetValue)
+	}
+}
+
+func newContactSensor() (*hcservice.Service, func(string) error) {
+	svc := hcservice.NewContactSensor()
+
+	return svc.Service, func(output string) error {
+		return setBool(output, func(contact bool) {
+			svc.ContactSensorState.SetValue(boolToInt(contact, characteristic.ContactSensorStateContactDetected,
+				characteristic.ContactSensorStateContactNotDetected))
+		})
+	}
+}
+
+func newOccupancySensor() (*hcservice.Service, func(string) error) {
+	svc := hcservice.NewOccupancySensor()
+
+	return svc.Service, func(output string) error {
+		return setBool(output, func(occupied bool) {
+			svc.OccupancyDetected.SetValue(boolToInt(occupied, characteristic.OccupancyDetectedOccupancyDetected,
+				characteristic.OccupancyDetectedOccupancyNotDetected))
+		})
+	}
+}
+
+func newMotionSensor() (*hcservice.Service, func(string) error) {
+	svc := hcservice.NewMotionSensor()
+
+	return svc.Service, func(output string) error {
+		return setBool(output, svc.MotionDetected.SetValue)
+	}
+}
+
+func setFloat(output string, set func(float64)) error {
+	f, err := strconv.ParseFloat(output, 64)
+	if err != nil {
+		return fmt.Errorf("invalid output %q: not a number", output)
+	}
+
+	set(f)
+
+	return nil
+}
+
+func setBool(output string, set func(bool)) error {
+	switch strings.ToLower(output) {
+	case "true", "yes", "on", "1":
+		set(true)
+	case "false", "no", "off", "0":
+		set(false)
+	default:
+		return fmt.Errorf("invalid output %q: not true or false", output)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package homekit
+
+import (
+	"context"
+	"github.com/brutella/hc/accessory"
+	"github.com/brutella/hc/characteristic"
+	"io/ioutil"
+	"mrz.io/hkswitch/app/config"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetFloat(t *testing.T) {
+	tests := []struct {
+		output string
+		want   float64
+		err    bool
+	}{
+		{output: "21.5", want: 21.5},
+		{output: "-4", want: -4},
+		{output: "1e3", want: 1000},
+		{output: "21.5 C", err: true},
+		{output: "", err: true},
+	}
+
+	for _, tt := range tests {
+		var value float64
+		err := setFloat(tt.output, func(f float64) { value = f })
+
+		if is, want := err != nil, tt.err; is != want {
+			t.Fatalf("%q: is = %v, want = %v", tt.output, err, want)
+		}
+
+		if is, want := value, tt.want; !tt.err && is != want {
+			t.Fatalf("%q: is = %v, want = %v", tt.output, is, want)
+		}
+	}
+}
+
+func TestSetBool(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+		err    bool
+	}{
+		{output: "true", want: true},
+		{output: "YES", want: true},
+		{output: "on", want: true},
+		{output: "1", want: true},
+		{output: "false", want: false},
+		{output: "No", want: false},
+		{output: "off", want: false},
+		{output: "0", want: false},
+		{output: "2", err: true},
+		{output: "", err: true},
+	}
+
+	for _, tt := range tests {
+		var set bool
+		var value bool
+		err := setBool(tt.output, func(b bool) { set, value = true, b })
+
+		if is, want := err != nil, tt.err; is != want {
+			t.Fatalf("%q: is = %v, want = %v", tt.output, err, want)
+		}
+
+		if is, want := set, !tt.err; is != want {
+			t.Fatalf("%q: is = %v, want = %v", tt.output, is, want)
+		}
+
+		if is, want := value, tt.want; is != want {
+			t.Fatalf("%q: is = %v, want = %v", tt.output, is, want)
+		}
+	}
+}
+
+// characteristicOf returns the accessory's characteristic of type typ.
+func characteristicOf(t *testing.T, acc *accessory.Accessory, typ string) *characteristic.Characteristic {
+	t.Helper()
+
+	for _, svc := range acc.GetServices() {
+		for _, c := range svc.GetCharacteristics() {
+			if c.Type == typ {
+				return c
+			}
+		}
+	}
+
+	t.Fatalf("is = %v, want = %v", nil, typ)
+	return nil
+}
+
+func TestSensor_Probe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "temperature")
+
+	cfg := config.Sensor{
+		Name:    "temperature",
+		Type:    config.SensorTemperature,
+		Command: []string{"bash", "-c", "test -s " + path + " && cat " + path},
+	}
+
+	s, err := newSensor(cfg, accessory.Info{Name: cfg.Name})
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	temperature := characteristicOf(t, s.Accessory, characteristic.TypeCurrentTemperature)
+
+	tests := []struct {
+		name   string
+		output string
+		want   float64
+		fault  int
+	}{
+		{name: "value", output: "21.5\n", want: 21.5, fault: characteristic.StatusFaultNoFault},
+		{name: "not a number", output: "hot\n", want: 21.5, fault: characteristic.StatusFaultGeneralFault},
+		{name: "recovered", output: "-3", want: -3, fault: characteristic.StatusFaultNoFault},
+		{name: "command failed", output: "", want: -3, fault: characteristic.StatusFaultGeneralFault},
+		{name: "recovered again", output: "20", want: 20, fault: characteristic.StatusFaultNoFault},
+	}
+
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(path, []byte(tt.output), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		s.probe(context.Background())
+
+		if is, want := temperature.Value, interface{}(tt.want); is != want {
+			t.Fatalf("%s: is = %v, want = %v", tt.name, is, want)
+		}
+
+		if is, want := s.statusFault.GetValue(), tt.fault; is != want {
+			t.Fatalf("%s: fault: is = %v, want = %v", tt.name, is, want)
+		}
+	}
+}
+
+func TestSensor_ProbeContact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contact")
+
+	cfg := config.Sensor{Name: "door", Type: config.SensorContact, Command: []string{"cat", path}}
+
+	s, err := newSensor(cfg, accessory.Info{Name: cfg.Name})
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	state := characteristicOf(t, s.Accessory, characteristic.TypeContactSensorState)
+
+	tests := []struct {
+		output string
+		want   int
+	}{
+		{output: "yes", want: characteristic.ContactSensorStateContactDetected},
+		{output: "off", want: characteristic.ContactSensorStateContactNotDetected},
+	}
+
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(path, []byte(tt.output), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		s.probe(context.Background())
+
+		if is, want := state.Value, interface{}(tt.want); is != want {
+			t.Fatalf("%q: is = %v, want = %v", tt.output, is, want)
+		}
+	}
+}
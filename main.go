@@ -7,6 +7,7 @@ import (
 	"mrz.io/hkswitch/app"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 )
 
@@ -43,6 +44,19 @@ var printLaunchdConfCmd = &cobra.Command{
 	},
 }
 
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade PID",
+	Short: "Replace a running hkswitch with the current binary, leaving its services running",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pid, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid pid %q", args[0])
+		}
+		return app.Upgrade(pid)
+	},
+}
+
 var rootCmd = &cobra.Command{
 	Version: version,
 	Use:     fmt.Sprintf("%s CONFIG_FILE", os.Args[0]),
@@ -62,7 +76,7 @@ func init() {
 		" generated config file")
 
 	printConfCmd.AddCommand(printSystemdConfCmd, printLaunchdConfCmd)
-	rootCmd.AddCommand(printConfCmd)
+	rootCmd.AddCommand(printConfCmd, upgradeCmd)
 }
 
 func main() {
@@ -1,10 +1,13 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -35,7 +38,7 @@ func GetSignal(s string) (syscall.Signal, bool) {
 // commandFactory creates an *exec.Cmd with platform dependant settings. This only exists to split platform specific
 // code such as SysProcAttr's fields in separate files with build tags and avoid Goland marking a lot of stuff in red.
 // This project depends on "unix-only" libraries anyway.
-type commandFactory func(path string, args ...string) *exec.Cmd
+type commandFactory func(c *Command) *exec.Cmd
 
 var factory commandFactory
 
@@ -61,6 +64,18 @@ type Command struct {
 	// GracePeriod specifies the max time to wait for the program to quit on its own before it is
 	// killed after a call to Stop on the handle returned by the call to Start.
 	GracePeriod time.Duration
+
+	// KillOnParentDeath makes the kernel kill the program when hkswitch dies, even if it's killed with SIGKILL. Only
+	// supported on Linux, ignored elsewhere.
+	KillOnParentDeath bool
+
+	// TTY runs the program in a new pseudo-terminal, which is its stdin, stdout and stderr, so that it behaves like
+	// when started from an interactive shell. All output is written to the stdout writer passed to Start.
+	TTY bool
+
+	// Rows and Cols set the pseudo-terminal's window size when TTY is set, 24 rows and 80 columns if zero.
+	Rows uint16
+	Cols uint16
 }
 
 // Start starts the command using the given writers as its stdout and stderr. An error with a nil Handle is returned
@@ -74,42 +89,237 @@ func (c *Command) Start(stdout, stderr io.Writer) (*handle, error) {
 		c.StopSignal = syscall.SIGTERM
 	}
 
-	cmd := factory(c.Path, c.Args...)
+	cmd := factory(c)
 
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
 	cmd.Dir = c.Workdir
 	cmd.Env = append(os.Environ(), c.Env...)
 
+	if c.TTY {
+		return c.startTTY(cmd, stdout, stderr)
+	}
+
+	// the pipes are created here instead of letting exec.Cmd do it, so that their read ends can be handed over to
+	// another process by Detach.
+	stdoutPipe, stdoutw, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("command: %w", err)
+	}
+
+	stderrPipe, stderrw, err := os.Pipe()
+	if err != nil {
+		_ = stdoutPipe.Close()
+		_ = stdoutw.Close()
+		return nil, fmt.Errorf("command: %w", err)
+	}
+
+	cmd.Stdout = stdoutw
+	cmd.Stderr = stderrw
+
 	writeMessage(stderr, fmt.Sprintf("starting %q with args %+q in working dir %q", c.Path, c.Args, c.Workdir))
 
-	if err := cmd.Start(); err != nil {
+	err = startTracked(cmd, stderr)
+
+	// the program has its own copy of the write ends now
+	_ = stdoutw.Close()
+	_ = stderrw.Close()
+
+	if err != nil {
+		_ = stdoutPipe.Close()
+		_ = stderrPipe.Close()
+
 		err := fmt.Errorf("command: %w", err)
 		writeMessage(stderr, err.Error())
 		return nil, err
 	}
 
-	return newHandle(cmd, stderr, c.StopSignal, c.GracePeriod), nil
+	h := watch(cmd.Process, cmd.Wait, stdoutPipe, stderrPipe, stdout, stderr, c.StopSignal, c.GracePeriod)
+	h.pinned = c.KillOnParentDeath && parentDeathSignal
+
+	return h, nil
+}
+
+// Run runs the command until it exits, using the given writers as its stdout and stderr, returning an error if the
+// command fails to start or exits with a non-zero exit code. Unlike Start, it writes no messages to stderr. When ctx
+// is done before the program exits, the program's process group is killed and ctx.Err() is returned.
+func (c *Command) Run(ctx context.Context, stdout, stderr io.Writer) error {
+	if c.Path == "" {
+		return fmt.Errorf("empty command path")
+	}
+
+	cmd := factory(c)
+
+	cmd.Dir = c.Workdir
+	cmd.Env = append(os.Environ(), c.Env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := startTracked(cmd, stderr); err != nil {
+		return fmt.Errorf("command: %w", err)
+	}
+
+	defer untrack(cmd.Process.Pid)
+
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-waitCh:
+		return err
+	case <-ctx.Done():
+		// the whole group, so that the children of the program don't keep the output pipes open
+		killGroup(cmd.Process)
+		<-waitCh
+		return ctx.Err()
+	}
+}
+
+// startTTY starts cmd in a new pseudo-terminal, copying its output to stdout.
+func (c *Command) startTTY(cmd *exec.Cmd, stdout, stderr io.Writer) (*handle, error) {
+	rows, cols := c.Rows, c.Cols
+	if rows == 0 {
+		rows = 24
+	}
+
+	if cols == 0 {
+		cols = 80
+	}
+
+	master, slave, err := attachPTY(cmd, rows, cols)
+	if err != nil {
+		err := fmt.Errorf("command: %w", err)
+		writeMessage(stderr, err.Error())
+		return nil, err
+	}
+
+	writeMessage(stderr, fmt.Sprintf("starting %q with args %+q in working dir %q with a %dx%d tty", c.Path, c.Args,
+		c.Workdir, cols, rows))
+
+	err = startTracked(cmd, stderr)
+	_ = slave.Close()
+
+	if err != nil {
+		_ = master.Close()
+
+		err := fmt.Errorf("command: %w", err)
+		writeMessage(stderr, err.Error())
+		return nil, err
+	}
+
+	h := watch(cmd.Process, cmd.Wait, master, nil, stdout, stderr, c.StopSignal, c.GracePeriod)
+	h.tty = master
+	h.pinned = c.KillOnParentDeath && parentDeathSignal
+
+	return h, nil
+}
+
+// Adopt monitors a program started by a previous hkswitch process, as described by d, copying its output to stdout
+// and stderr. The program must be a child of the current process, which is the case when hkswitch replaced itself
+// with exec(2).
+func (c *Command) Adopt(d Detached, stdout, stderr io.Writer) (*handle, error) {
+	if c.StopSignal == 0 {
+		c.StopSignal = syscall.SIGTERM
+	}
+
+	// tracked first, so that the reaper can't reap the program, losing its exit status, if it exits meanwhile
+	track(d.Pid, stderr)
+
+	process, err := os.FindProcess(d.Pid)
+	if err == nil {
+		err = process.Signal(syscall.Signal(0))
+	}
+
+	if err != nil {
+		untrack(d.Pid)
+		closeFile(d.Stdout)
+		closeFile(d.Stderr)
+
+		err := fmt.Errorf("command: adopt pid %d: %w", d.Pid, err)
+		writeMessage(stderr, err.Error())
+		return nil, err
+	}
+
+	writeMessage(stderr, fmt.Sprintf("adopted %q with pid %d", c.Path, d.Pid))
+
+	h := watch(process, waitProcess(process), d.Stdout, d.Stderr, stdout, stderr, c.StopSignal, c.GracePeriod)
+	h.pinned = c.KillOnParentDeath && parentDeathSignal
+	if c.TTY {
+		h.tty = d.Stdout
+	}
+
+	return h, nil
+}
+
+// Detached describes a running program and the read ends of the pipes connected to its stdout and stderr, so that
+// it can be adopted by another process.
+type Detached struct {
+	Pid    int
+	Stdout *os.File
+	Stderr *os.File
 }
 
 // handle represents a running program.
 type handle struct {
-	cmd *exec.Cmd
+	process *os.Process
 
 	err    error
 	doneCh chan struct{}
 
+	stdoutPipe *os.File
+	stderrPipe *os.File
+
+	// copies are the goroutines copying the output read from the pipes.
+	copies *sync.WaitGroup
+
+	// closed are the pipes closed by their copy, as it reached EOF.
+	closed   map[*os.File]bool
+	closedMu sync.Mutex
+
+	// tty is the pseudo-terminal's master, when the program runs in one.
+	tty *os.File
+
+	// pinned is set when the program is killed when the thread that started it exits, see setParentDeathSignal.
+	pinned bool
+
 	gracePeriod time.Duration
 	stopSignal  os.Signal
 }
 
 // newHandle creates a new *handle for the given - already started - *exec.Cmd.
 func newHandle(cmd *exec.Cmd, stderr io.Writer, stopSignal os.Signal, gracePeriod time.Duration) *handle {
+	return watch(cmd.Process, cmd.Wait, nil, nil, nil, stderr, stopSignal, gracePeriod)
+}
+
+// watch creates a new *handle for an already started process, calling wait to wait for it to finish. The output
+// read from stdoutPipe and stderrPipe, when not nil, is copied to stdout and stderr.
+func watch(process *os.Process, wait func() error, stdoutPipe, stderrPipe *os.File, stdout, stderr io.Writer,
+	stopSignal os.Signal, gracePeriod time.Duration) *handle {
 	doneCh := make(chan struct{})
-	h := &handle{cmd: cmd, gracePeriod: gracePeriod, doneCh: doneCh, stopSignal: stopSignal}
+	h := &handle{
+		process:     process,
+		gracePeriod: gracePeriod,
+		doneCh:      doneCh,
+		stopSignal:  stopSignal,
+		stdoutPipe:  stdoutPipe,
+		stderrPipe:  stderrPipe,
+		copies:      &sync.WaitGroup{},
+		closed:      make(map[*os.File]bool),
+	}
+
+	copies := h.copies
+	h.copyPipe(stdout, stdoutPipe)
+	h.copyPipe(stderr, stderrPipe)
 
 	go func() {
-		if err := cmd.Wait(); err != nil {
+		err := wait()
+
+		// the copies finish when every process holding the write ends, including the children of the program,
+		// has exited.
+		copies.Wait()
+		untrack(process.Pid)
+
+		if err != nil {
 			writeMessage(stderr, err.Error())
 			h.err = err
 		} else {
@@ -130,10 +340,12 @@ func (h *handle) Wait() error {
 }
 
 // Stop sends the signal set as Command.StopSignal before the call to Command.Start() (SIGTERM by default), and later
-// SIGKILL if the program does not terminate before Command.GracePeriod expires.
+// SIGKILL if the program does not terminate before Command.GracePeriod expires. The signals are sent to the program's
+// process group, so that its descendants stop too, even those orphaned and adopted by the reaper. When the program
+// runs in a pseudo-terminal, they are sent to the terminal's foreground process group.
 func (h *handle) Stop() {
 	if h.gracePeriod == 0 || h.stopSignal == syscall.SIGKILL {
-		_ = h.cmd.Process.Signal(syscall.SIGKILL)
+		h.signal(syscall.SIGKILL)
 		return
 	}
 
@@ -142,11 +354,126 @@ func (h *handle) Stop() {
 		case <-h.doneCh:
 			return
 		case <-time.After(h.gracePeriod):
-			_ = h.cmd.Process.Signal(syscall.SIGKILL)
+			h.signal(syscall.SIGKILL)
+		}
+	}()
+
+	h.signal(h.stopSignal)
+}
+
+func (h *handle) signal(sig os.Signal) {
+	if h.tty != nil && signalForeground(h.tty, sig) == nil {
+		return
+	}
+
+	_ = signalGroup(h.process, sig)
+}
+
+// Detach describes the running program so that it can be adopted by another process. The handle stops copying the
+// program's output, returning once what was read from the pipes is written, and leaves the pipes open for the process
+// adopting it. A pipe already closed, because its copy reached EOF as the program closed its end or exited, is
+// left out. It returns false for a program with a parent death signal, which would be killed when the current
+// process is replaced.
+func (h *handle) Detach() (Detached, bool) {
+	if h.pinned {
+		return Detached{}, false
+	}
+
+	pipes := []*os.File{h.stdoutPipe, h.stderrPipe}
+
+	stopped := true
+	for _, pipe := range pipes {
+		// a pipe closed meanwhile fails too, as it's marked closed before being closed
+		if pipe != nil && pipe.SetReadDeadline(time.Now()) != nil && !h.pipeClosed(pipe) {
+			stopped = false
+		}
+	}
+
+	// the copies of pipes that don't support deadlines go on until the current process is replaced
+	if stopped {
+		h.copies.Wait()
+	}
+
+	for i, pipe := range pipes {
+		if pipe == nil {
+			continue
 		}
+
+		if h.pipeClosed(pipe) {
+			pipes[i] = nil
+		} else if stopped {
+			_ = pipe.SetReadDeadline(time.Time{})
+		}
+	}
+
+	return Detached{Pid: h.process.Pid, Stdout: pipes[0], Stderr: pipes[1]}, true
+}
+
+// pipeClosed returns whether the pipe was closed by its copy.
+func (h *handle) pipeClosed(pipe *os.File) bool {
+	h.closedMu.Lock()
+	defer h.closedMu.Unlock()
+
+	return h.closed[pipe]
+}
+
+// waitProcess returns a function that waits for a process that was not started by the current process (but is
+// still its child), reporting an unsuccessful exit as an *exec.ExitError like exec.Cmd.Wait does.
+func waitProcess(process *os.Process) func() error {
+	return func() error {
+		state, err := process.Wait()
+		if err != nil {
+			return err
+		}
+
+		if !state.Success() {
+			return &exec.ExitError{ProcessState: state}
+		}
+
+		return nil
+	}
+}
+
+// copyPipe copies from src to dst in the background until src reaches EOF, closing it, or its read deadline, set by
+// Detach, is exceeded. Does nothing when src is nil. Errors writing to dst are ignored, so that the pipe keeps being
+// drained and the program doesn't block or get a SIGPIPE.
+func (h *handle) copyPipe(dst io.Writer, src *os.File) {
+	if src == nil {
+		return
+	}
+
+	h.copies.Add(1)
+
+	go func() {
+		defer h.copies.Done()
+
+		_, err := io.Copy(ignoreErrors{dst}, src)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// detached: the pipe is left open for the process adopting the program
+			return
+		}
+
+		h.closedMu.Lock()
+		h.closed[src] = true
+		_ = src.Close()
+		h.closedMu.Unlock()
 	}()
+}
+
+// ignoreErrors is an io.Writer that always reports success.
+type ignoreErrors struct {
+	w io.Writer
+}
+
+func (w ignoreErrors) Write(data []byte) (int, error) {
+	_, _ = w.w.Write(data)
+	return len(data), nil
+}
 
-	_ = h.cmd.Process.Signal(h.stopSignal)
+func closeFile(f *os.File) {
+	if f != nil {
+		_ = f.Close()
+	}
 }
 
 func writeMessage(dst io.Writer, msg string) {
@@ -0,0 +1,12 @@
+// +build darwin
+
+package service
+
+import "syscall"
+
+// parentDeathSignal is false as setParentDeathSignal does nothing.
+const parentDeathSignal = false
+
+// setParentDeathSignal does nothing, as there's no parent death signal on macOS.
+func setParentDeathSignal(attr *syscall.SysProcAttr, sig syscall.Signal) {
+}
@@ -0,0 +1,17 @@
+// +build linux
+
+package service
+
+import "syscall"
+
+// parentDeathSignal is true as setParentDeathSignal is supported.
+const parentDeathSignal = true
+
+// setParentDeathSignal sets the signal the program receives when hkswitch dies. Note that the kernel sends it when
+// the OS thread that started the program exits, not the process: Go runs goroutines on any of its threads, and
+// exec(2) ends every thread but the calling one, so the program is killed when hkswitch replaces itself on upgrade
+// unless the exec happens to run on that thread. Such programs are restarted after an upgrade instead of being
+// handed over (see handle.Detach).
+func setParentDeathSignal(attr *syscall.SysProcAttr, sig syscall.Signal) {
+	attr.Pdeathsig = sig
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -115,6 +116,34 @@ func TestCommand_Start_Stop(t *testing.T) {
 	wg.Wait()
 }
 
+func TestCommand_Start_Stop_Group(t *testing.T) {
+	// the child keeps the output pipe open unless it's stopped with bash
+	cmd := &Command{
+		Path:        "bash",
+		Args:        []string{"-c", "sleep 30 & wait"},
+		GracePeriod: 10 * time.Second,
+	}
+
+	handle, err := cmd.Start(&bytes.Buffer{}, &bytes.Buffer{})
+
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	handle.Stop()
+
+	if is, want := handle.Wait(), "signal: terminated"; is == nil || is.Error() != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Wait returned after %s", elapsed)
+	}
+}
+
 func TestCommand_Start_WithError(t *testing.T) {
 	cmd := &Command{Path: "foo"}
 
@@ -132,3 +161,236 @@ func TestCommand_Start_WithError(t *testing.T) {
 	}
 
 }
+
+func TestCommand_Adopt(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the program is not waited for until adopted, so it can be adopted even after it exited
+	started := exec.Command("bash", "-c", "echo hello")
+	started.Stdout = w
+
+	if err := started.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Close()
+
+	cmd := &Command{Path: "bash", GracePeriod: 5 * time.Second}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	handle, err := cmd.Adopt(Detached{Pid: started.Process.Pid, Stdout: r}, stdout, stderr)
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	if is, want := handle.Wait(), error(nil); is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is, want := stdout.String(), "hello\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	detached, ok := handle.Detach()
+	if is, want := ok, true; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is, want := detached.Pid, started.Process.Pid; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestHandle_Detach(t *testing.T) {
+	cmd := &Command{Path: "bash", Args: []string{"-c", "echo before; sleep 0.5; echo after"}}
+
+	stdout := &syncBuffer{}
+	stderr := &syncBuffer{}
+
+	handle, err := cmd.Start(stdout, stderr)
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	defer handle.Stop()
+
+	for stdout.String() == "" {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	detached, ok := handle.Detach()
+	if is, want := ok, true; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	// the rest of the output is left in the pipe for the process adopting the program
+	rest, err := ioutil.ReadAll(detached.Stdout)
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	if is, want := string(rest), "after\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+
+	if is, want := stdout.String(), "before\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestHandle_Detach_ClosedPipe(t *testing.T) {
+	cmd := &Command{Path: "bash", Args: []string{"-c", "echo closing; exec 1>&-; sleep 30"}}
+
+	stdout := &syncBuffer{}
+
+	handle, err := cmd.Start(stdout, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	defer handle.Stop()
+
+	for stdout.String() == "" {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// for the copy to reach EOF, closing the pipe
+	time.Sleep(100 * time.Millisecond)
+
+	detached, ok := handle.Detach()
+	if is, want := ok, true; is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is := detached.Stdout; is != nil {
+		t.Fatalf("is = %v, want = %v", is, nil)
+	}
+
+	if is := detached.Stderr; is == nil {
+		t.Fatalf("is = %v, want the stderr pipe", is)
+	}
+}
+
+func TestCommand_Adopt_NotRunning(t *testing.T) {
+	started := exec.Command("true")
+	if err := started.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &Command{Path: "true"}
+
+	handle, err := cmd.Adopt(Detached{Pid: started.Process.Pid}, &bytes.Buffer{}, &bytes.Buffer{})
+	if err == nil {
+		t.Fatalf("is = %v, want an error", err)
+	}
+
+	if handle != nil {
+		t.Fatalf("is = %v, want = nil", handle)
+	}
+}
+
+func TestCommand_Start_TTY(t *testing.T) {
+	cmd := &Command{
+		Path: "bash",
+		Args: []string{"-c", "test -t 0 && test -t 1 && stty size"},
+		TTY:  true,
+		Rows: 30,
+		Cols: 100,
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	handle, err := cmd.Start(stdout, stderr)
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	if is, want := handle.Wait(), error(nil); is != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+
+	if is, want := stdout.String(), "30 100\n"; is != want {
+		t.Fatalf("is = %q, want = %q", is, want)
+	}
+}
+
+func TestCommand_Start_Stop_TTY(t *testing.T) {
+	cmd := &Command{
+		Path:        "bash",
+		Args:        []string{"-c", "sleep 10; true"},
+		TTY:         true,
+		GracePeriod: 5 * time.Second,
+	}
+
+	handle, err := cmd.Start(&bytes.Buffer{}, &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+
+	handle.Stop()
+
+	if is, want := handle.Wait(), "signal: terminated"; is == nil || is.Error() != want {
+		t.Fatalf("is = %v, want = %v", is, want)
+	}
+}
+
+func TestCommand_Run(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	c := &Command{Path: "bash", Args: []string{"-c", "echo out; echo err >&2"}}
+
+	if is, want := c.Run(context.Background(), stdout, stderr), error(nil); is != want {
+		t.Fatalf("is=%v, want=%v", is, want)
+	}
+
+	if is, want := stdout.String(), "out\n"; is != want {
+		t.Fatalf("stdout: is=%q, want=%q", is, want)
+	}
+
+	if is, want := stderr.String(), "err\n"; is != want {
+		t.Fatalf("stderr: is=%q, want=%q", is, want)
+	}
+}
+
+func TestCommand_Run_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// the child sleep holds stdout open, and must be killed too for Run to return
+	c := &Command{Path: "bash", Args: []string{"-c", "sleep 10; true"}}
+
+	start := time.Now()
+	if is, want := c.Run(ctx, &bytes.Buffer{}, &bytes.Buffer{}), context.DeadlineExceeded; is != want {
+		t.Fatalf("is=%v, want=%v", is, want)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Run returned after %s", elapsed)
+	}
+}
+
+// syncBuffer is a bytes.Buffer that can be read while a handle writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(data)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
@@ -3,6 +3,7 @@
 package service
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 )
@@ -11,11 +12,37 @@ func init() {
 	factory = unixCommandFactory
 }
 
-func unixCommandFactory(path string, args ...string) *exec.Cmd {
-	cmd := exec.Command(path, args...)
+func unixCommandFactory(c *Command) *exec.Cmd {
+	cmd := exec.Command(c.Path, c.Args...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 
+	if c.KillOnParentDeath {
+		setParentDeathSignal(cmd.SysProcAttr, syscall.SIGKILL)
+	}
+
 	return cmd
 }
+
+// killGroup kills the process group led by process, which every program started by Command leads.
+func killGroup(process *os.Process) {
+	if err := syscall.Kill(-process.Pid, syscall.SIGKILL); err != nil {
+		_ = process.Kill()
+	}
+}
+
+// signalGroup sends sig to the process group led by process, or to process alone once it has been waited for, as
+// its pid, and so its group, may then be reused.
+func signalGroup(process *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok || process.Signal(syscall.Signal(0)) != nil {
+		return process.Signal(sig)
+	}
+
+	if err := syscall.Kill(-process.Pid, s); err != nil {
+		return process.Signal(sig)
+	}
+
+	return nil
+}
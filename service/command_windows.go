@@ -3,19 +3,31 @@
 package service
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 )
 
+// parentDeathSignal is false as Command.KillOnParentDeath is ignored.
+const parentDeathSignal = false
+
 func init() {
 	factory = windowsCommandFactory
 }
 
-func windowsCommandFactory(path string, args ...string) *exec.Cmd {
-	cmd := exec.Command(path, args...)
+func windowsCommandFactory(c *Command) *exec.Cmd {
+	cmd := exec.Command(c.Path, c.Args...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
 	}
 
 	return cmd
 }
+
+func killGroup(process *os.Process) {
+	_ = process.Kill()
+}
+
+func signalGroup(process *os.Process, sig os.Signal) error {
+	return process.Signal(sig)
+}
@@ -0,0 +1,54 @@
+// +build darwin
+
+package service
+
+import (
+	"bytes"
+	"golang.org/x/sys/unix"
+	"os"
+	"unsafe"
+)
+
+const (
+	getTermiosRequest = unix.TIOCGETA
+	setTermiosRequest = unix.TIOCSETA
+)
+
+// openPTY opens a new pseudo-terminal pair from /dev/ptmx, like posix_openpt, grantpt, unlockpt and ptsname do.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fd := int(master.Fd())
+
+	if err := unix.IoctlSetInt(fd, unix.TIOCPTYGRANT, 0); err != nil {
+		_ = master.Close()
+		return nil, nil, err
+	}
+
+	if err := unix.IoctlSetInt(fd, unix.TIOCPTYUNLK, 0); err != nil {
+		_ = master.Close()
+		return nil, nil, err
+	}
+
+	name := make([]byte, 128)
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.TIOCPTYGNAME,
+		uintptr(unsafe.Pointer(&name[0]))); errno != 0 {
+		_ = master.Close()
+		return nil, nil, errno
+	}
+
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	slave, err = os.OpenFile(string(name), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, nil, err
+	}
+
+	return master, slave, nil
+}
@@ -0,0 +1,87 @@
+// +build linux darwin
+
+package service
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// attachPTY opens a new pseudo-terminal of the given size and sets it as the controlling terminal, stdin, stdout
+// and stderr of cmd, which is started as a new session. The caller must close slave once cmd has started.
+func attachPTY(cmd *exec.Cmd, rows, cols uint16) (master, slave *os.File, err error) {
+	master, slave, err = openPTY()
+	if err != nil {
+		return nil, nil, fmt.Errorf("pty: %w", err)
+	}
+
+	if err := configurePTY(slave, rows, cols); err != nil {
+		_ = master.Close()
+		_ = slave.Close()
+		return nil, nil, fmt.Errorf("pty: %w", err)
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+
+	// a session leader can't be moved to a new process group, but it's the leader of its own group anyway.
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+
+	return master, slave, nil
+}
+
+// configurePTY sets the window size and disables translating "\n" into "\r\n" in the output, which would otherwise
+// end up in hkswitch's output.
+func configurePTY(tty *os.File, rows, cols uint16) error {
+	fd := int(tty.Fd())
+
+	if err := unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{Row: rows, Col: cols}); err != nil {
+		return err
+	}
+
+	termios, err := unix.IoctlGetTermios(fd, getTermiosRequest)
+	if err != nil {
+		return err
+	}
+
+	termios.Oflag &^= unix.ONLCR
+
+	return unix.IoctlSetTermios(fd, setTermiosRequest, termios)
+}
+
+// signalForeground sends sig to the foreground process group of the terminal, which is the program itself unless
+// it started a job of its own, like a shell does.
+func signalForeground(tty *os.File, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %s", sig)
+	}
+
+	// unlike Fd, Control is safe to use while the terminal is being closed by the goroutine copying its output.
+	conn, err := tty.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var pgid int
+	var ioctlErr error
+
+	if err := conn.Control(func(fd uintptr) {
+		pgid, ioctlErr = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	}); err != nil {
+		return err
+	}
+
+	if ioctlErr != nil {
+		return ioctlErr
+	}
+
+	return syscall.Kill(-pgid, s)
+}
@@ -0,0 +1,17 @@
+// +build windows
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func attachPTY(cmd *exec.Cmd, rows, cols uint16) (master, slave *os.File, err error) {
+	return nil, nil, fmt.Errorf("pty: not supported")
+}
+
+func signalForeground(tty *os.File, sig os.Signal) error {
+	return fmt.Errorf("pty: not supported")
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// tracked holds the pids of the programs started or adopted by Command, which are waited for by their handle, and
+// the writer for their messages, until their handle finishes. The reaper only reaps the children of hkswitch that
+// are not tracked, and attributes them to a tracked program by process group.
+var tracked = struct {
+	sync.Mutex
+	pids map[int]io.Writer
+}{pids: make(map[int]io.Writer)}
+
+// startTracked starts cmd and tracks its pid, so that the reaper can't reap the program before it's tracked.
+func startTracked(cmd *exec.Cmd, stderr io.Writer) error {
+	tracked.Lock()
+	defer tracked.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	tracked.pids[cmd.Process.Pid] = stderr
+
+	return nil
+}
+
+func track(pid int, stderr io.Writer) {
+	tracked.Lock()
+	defer tracked.Unlock()
+
+	tracked.pids[pid] = stderr
+}
+
+func untrack(pid int) {
+	tracked.Lock()
+	defer tracked.Unlock()
+
+	delete(tracked.pids, pid)
+}
@@ -0,0 +1,185 @@
+// +build linux
+
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const prSetChildSubreaper = 36
+
+// StartReaper makes hkswitch the child subreaper of the programs it starts, so that their orphaned descendants
+// (eg. the children of a `bash -c` wrapper that exited) are re-parented to hkswitch instead of init. Orphans are
+// reaped when they exit, and reported to the stderr of the program in the same process group, or to w when that
+// program is not running anymore.
+func StartReaper(w io.Writer) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return fmt.Errorf("reaper: %w", errno)
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGCHLD)
+
+	go func() {
+		for range ch {
+			reapOrphans(w)
+		}
+	}()
+
+	return nil
+}
+
+// reapOrphans waits for the exited children of hkswitch that are not tracked. The children are found by waitid, which
+// returns one of them without reaping it; all processes are only listed when it returns a tracked program, which is
+// waited for by its handle, as the orphans that exited too are behind it.
+func reapOrphans(w io.Writer) {
+	tracked.Lock()
+	defer tracked.Unlock()
+
+	for {
+		pid := exitedChild()
+		if pid <= 0 {
+			return
+		}
+
+		if _, ok := tracked.pids[pid]; ok {
+			reapAllOrphans(w)
+			return
+		}
+
+		p, ok := readProcess(pid)
+		if !ok || !reap(w, p) {
+			return
+		}
+	}
+}
+
+// reapAllOrphans waits for any exited child of hkswitch that is not tracked, among all processes.
+func reapAllOrphans(w io.Writer) {
+	self := os.Getpid()
+
+	for _, p := range listProcesses() {
+		if p.ppid != self {
+			continue
+		}
+
+		if _, ok := tracked.pids[p.pid]; ok {
+			continue
+		}
+
+		reap(w, p)
+	}
+}
+
+// reap waits for p, if it has exited, and reports it to the program in the same process group.
+func reap(w io.Writer, p process) bool {
+	var status syscall.WaitStatus
+	pid, err := syscall.Wait4(p.pid, &status, syscall.WNOHANG, nil)
+	if err != nil || pid != p.pid {
+		return false
+	}
+
+	dst, ok := tracked.pids[p.pgid]
+	if !ok {
+		dst = w
+	}
+
+	writeMessage(dst, fmt.Sprintf("reaped orphaned process %d (process group %d): %s", p.pid, p.pgid,
+		describeWaitStatus(status)))
+
+	return true
+}
+
+const pAll = 0
+
+// siginfo is the start of siginfo_t, as filled by waitid for SIGCHLD: si_pid follows the 3 ints of the header, in a
+// union aligned like a pointer.
+type siginfo struct {
+	signo int32
+	errno int32
+	code  int32
+	_     [0]uintptr
+	pid   int32
+	_     [128]byte
+}
+
+// exitedChild returns the pid of an exited child of hkswitch, without reaping it, or 0 if there is none.
+func exitedChild() int {
+	var info siginfo
+
+	_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pAll, 0, uintptr(unsafe.Pointer(&info)),
+		syscall.WEXITED|syscall.WNOHANG|syscall.WNOWAIT, 0, 0)
+	if errno != 0 {
+		return 0
+	}
+
+	return int(info.pid)
+}
+
+type process struct {
+	pid  int
+	ppid int
+	pgid int
+}
+
+// listProcesses reads the pid, parent pid and process group of all processes from /proc.
+func listProcesses() []process {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var list []process
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+
+		if p, ok := readProcess(pid); ok {
+			list = append(list, p)
+		}
+	}
+
+	return list
+}
+
+// readProcess reads the parent pid and process group of pid from /proc, which are kept until it's reaped.
+func readProcess(pid int) (process, bool) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return process{}, false
+	}
+
+	// the command name in parentheses may contain spaces, the fields that follow are: state, ppid, pgrp, ...
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return process{}, false
+	}
+
+	fields := bytes.Fields(data[i+1:])
+	if len(fields) < 3 {
+		return process{}, false
+	}
+
+	ppid, _ := strconv.Atoi(string(fields[1]))
+	pgid, _ := strconv.Atoi(string(fields[2]))
+
+	return process{pid: pid, ppid: ppid, pgid: pgid}, true
+}
+
+func describeWaitStatus(status syscall.WaitStatus) string {
+	if status.Signaled() {
+		return fmt.Sprintf("signal: %s", status.Signal())
+	}
+
+	return fmt.Sprintf("exit status %d", status.ExitStatus())
+}
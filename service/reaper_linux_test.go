@@ -0,0 +1,86 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListProcesses(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	for _, p := range listProcesses() {
+		if p.pid != cmd.Process.Pid {
+			continue
+		}
+
+		if is, want := p.ppid, os.Getpid(); is != want {
+			t.Fatalf("is = %v, want = %v", is, want)
+		}
+
+		if is, want := p.pgid, cmd.Process.Pid; is != want {
+			t.Fatalf("is = %v, want = %v", is, want)
+		}
+
+		return
+	}
+
+	t.Fatalf("process %d not found", cmd.Process.Pid)
+}
+
+func TestReapOrphans(t *testing.T) {
+	program := exec.Command("true")
+	if err := startTracked(program, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	defer untrack(program.Process.Pid)
+
+	// the tracked program exited first, and is returned by waitid before the orphan
+	deadline := time.Now().Add(5 * time.Second)
+	for exitedChild() != program.Process.Pid {
+		if time.Now().After(deadline) {
+			t.Fatalf("is = %v, want = %v", exitedChild(), program.Process.Pid)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	orphan := exec.Command("bash", "-c", "exit 3")
+	orphan.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := orphan.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &bytes.Buffer{}
+	want := fmt.Sprintf("reaped orphaned process %d (process group %d): exit status 3\n", orphan.Process.Pid,
+		orphan.Process.Pid)
+
+	for w.String() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("is = %q, want = %q", w.String(), want)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		reapOrphans(w)
+	}
+
+	// left to its handle
+	if err := program.Wait(); err != nil {
+		t.Fatalf("is = %v, want = %v", err, nil)
+	}
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package service
+
+import "io"
+
+// StartReaper does nothing, as child subreapers are only supported on Linux.
+func StartReaper(w io.Writer) error {
+	return nil
+}
@@ -26,19 +26,114 @@ type Handle interface {
 	Stop()
 }
 
+// Detacher is implemented by Handles whose program can keep running after the current process is replaced.
+type Detacher interface {
+	// Detach describes the running program so that it can be adopted by another process. It returns false when the
+	// program can't outlive the current process being replaced, and must be restarted instead.
+	Detach() (Detached, bool)
+}
+
+// Adopter is implemented by Services that can take over a program started by another process.
+type Adopter interface {
+	// Adopt returns a Handle for the program described by d, or a nil Handle and an error if the program can't be
+	// adopted, e.g. because it is not running anymore.
+	Adopt(d Detached) (Handle, error)
+}
+
+// State is the lifecycle state of a service tracked by a Manager.
+type State int
+
+const (
+	StateStopped State = iota
+	// StateStarting is the state of a service while its Service.Start runs.
+	StateStarting
+	StateRunning
+	// StateStopping is the state of a running service asked to stop, until it stops, e.g. while its program is
+	// given its grace period.
+	StateStopping
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	default:
+		return "stopped"
+	}
+}
+
+// CommandRunner is implemented by Services that can run another program, e.g. a hook, with their output.
+type CommandRunner interface {
+	// RunCommand runs cmd like Command.Run, its output going where the service's goes.
+	RunCommand(ctx context.Context, cmd *Command) error
+}
+
 type Change struct {
 	Service   Service
 	Running   bool
 	Timestamp time.Time
+
+	// State is the state the service entered. Only the Changes delivered by SubscribeTransitions can be in
+	// StateStarting or StateStopping, in which case Running tells whether the service's program is running.
+	State State
+
+	// Err is set when the service stopped with an error, or failed to start.
+	Err error
+
+	// Requested is set when the service stopped because it was asked to by Stop or Shutdown, in which case Err is
+	// usually the signal that stopped it.
+	Requested bool
+
+	// Instances is the number of instances of the service running and not asked to stop.
+	Instances int
+
+	// Scaled is set when the number of instances of a running service changed, and it keeps running: Err and
+	// Requested are then about the instance that stopped, if any.
+	Scaled bool
+}
+
+// exit is an instance of a service whose Handle.Wait returned.
+type exit struct {
+	svc    Service
+	handle Handle
+	err    error
+}
+
+// scaling asks for n instances of svc.
+type scaling struct {
+	svc Service
+	n   int
+}
+
+// status is the state of a service, and its number of instances.
+type status struct {
+	state     State
+	instances int
 }
 
 type query struct {
 	svc   Service
-	reply chan bool
+	reply chan status
 }
 
 func newQuery(svc Service) query {
-	return query{svc: svc, reply: make(chan bool)}
+	return query{svc: svc, reply: make(chan status)}
+}
+
+// subscription is a channel Changes are written to. Only the Changes to StateRunning and StateStopped are written
+// unless transitions is set.
+type subscription struct {
+	ch          chan Change
+	transitions bool
+}
+
+type adoption struct {
+	svc     Service
+	handles []Handle
 }
 
 type Callback func(svc Service, running bool)
@@ -50,19 +145,34 @@ type Manager struct {
 	// didShutdown is closed to signal that shutdown has finished.
 	didShutdown chan struct{}
 
-	// running is a map to a running Service and its Handle
-	running map[Service]Handle
+	// running is a map to a running Service and the Handles of its instances
+	running map[Service][]Handle
+
+	// stopping are the Handles of the running instances asked to stop.
+	stopping map[Handle]bool
+
+	// restarting are the stopping services to start again once stopped, and their number of instances.
+	restarting map[Service]int
 
 	// start
 	start   chan Service
 	stop    chan Service
-	stopped chan Service
+	restart chan Service
+	scale   chan scaling
+	stopped chan exit
 
-	subscribe     chan chan Change
+	subscribe     chan subscription
 	unsubscribe   chan chan Change
-	subscriptions []chan Change
+	subscriptions []subscription
 
 	queries chan query
+
+	adopt chan adoption
+
+	// handingOver is set before shutdown is closed to skip stopping the running services, which are moved to
+	// handedOver instead.
+	handingOver bool
+	handedOver  map[Service][]Handle
 }
 
 func NewManager() *Manager {
@@ -71,10 +181,15 @@ func NewManager() *Manager {
 		shutdown:    make(chan struct{}),
 		start:       make(chan Service),
 		stop:        make(chan Service),
-		stopped:     make(chan Service),
+		restart:     make(chan Service),
+		scale:       make(chan scaling),
+		stopped:     make(chan exit),
 		queries:     make(chan query),
-		running:     make(map[Service]Handle),
-		subscribe:   make(chan chan Change),
+		adopt:       make(chan adoption),
+		running:     make(map[Service][]Handle),
+		stopping:    make(map[Handle]bool),
+		restarting:  make(map[Service]int),
+		subscribe:   make(chan subscription),
 		unsubscribe: make(chan chan Change),
 	}
 
@@ -82,18 +197,24 @@ func NewManager() *Manager {
 	loop:
 		for {
 			select {
-			case ch := <-mgr.subscribe:
-				mgr.addSubscription(ch)
+			case sub := <-mgr.subscribe:
+				mgr.addSubscription(sub)
 			case rmCh := <-mgr.unsubscribe:
 				mgr.removeSubscription(rmCh)
 			case svc := <-mgr.start:
 				mgr.startService(svc)
+			case a := <-mgr.adopt:
+				mgr.adoptService(a.svc, a.handles)
 			case q := <-mgr.queries:
 				mgr.queryService(q)
 			case svc := <-mgr.stop:
 				mgr.stopService(svc)
-			case svc := <-mgr.stopped:
-				mgr.serviceStopped(svc)
+			case svc := <-mgr.restart:
+				mgr.restartService(svc)
+			case sc := <-mgr.scale:
+				mgr.scaleService(sc.svc, sc.n)
+			case e := <-mgr.stopped:
+				mgr.instanceStopped(e.svc, e.handle, e.err)
 			case <-mgr.shutdown:
 				break loop
 			}
@@ -110,6 +231,16 @@ func NewManager() *Manager {
 // There might still be Changes to read after subscription is canceled.
 // If Subscribe is called after shutdown a closed channel is returned.
 func (mgr *Manager) Subscribe(ctx context.Context) <-chan Change {
+	return mgr.subscribeChanges(ctx, false)
+}
+
+// SubscribeTransitions is like Subscribe, but the channel also gets a Change when a service is starting and when
+// it's asked to stop.
+func (mgr *Manager) SubscribeTransitions(ctx context.Context) <-chan Change {
+	return mgr.subscribeChanges(ctx, true)
+}
+
+func (mgr *Manager) subscribeChanges(ctx context.Context, transitions bool) <-chan Change {
 	ch := make(chan Change, 1000)
 
 	select {
@@ -129,14 +260,14 @@ func (mgr *Manager) Subscribe(ctx context.Context) <-chan Change {
 			}
 		}()
 
-		mgr.subscribe <- ch
+		mgr.subscribe <- subscription{ch: ch, transitions: transitions}
 	}
 
 	return ch
 }
 
-func (mgr *Manager) addSubscription(ch chan Change) {
-	mgr.subscriptions = append(mgr.subscriptions, ch)
+func (mgr *Manager) addSubscription(sub subscription) {
+	mgr.subscriptions = append(mgr.subscriptions, sub)
 }
 
 func (mgr *Manager) removeSubscription(rmCh chan Change) {
@@ -144,21 +275,28 @@ func (mgr *Manager) removeSubscription(rmCh chan Change) {
 		close(rmCh)
 	}()
 
-	var tmp []chan Change
-	for _, ch := range mgr.subscriptions {
-		if ch != rmCh {
-			tmp = append(tmp, ch)
+	var tmp []subscription
+	for _, sub := range mgr.subscriptions {
+		if sub.ch != rmCh {
+			tmp = append(tmp, sub)
 		}
 	}
 	mgr.subscriptions = tmp
 }
 
-func (mgr *Manager) notifySubscribers(svc Service, running bool) {
-	t := time.Now()
-	for _, ch := range mgr.subscriptions {
+// notifySubscribers writes c, timestamped, to the subscriptions.
+func (mgr *Manager) notifySubscribers(c Change) {
+	c.Timestamp = time.Now()
+	transition := c.State == StateStarting || c.State == StateStopping
+
+	for _, sub := range mgr.subscriptions {
+		if transition && !sub.transitions {
+			continue
+		}
+
 		select {
 		case <-mgr.shutdown:
-		case ch <- Change{Service: svc, Running: running, Timestamp: t}:
+		case sub.ch <- c:
 		default:
 		}
 	}
@@ -168,19 +306,27 @@ func (mgr *Manager) notifySubscribers(svc Service, running bool) {
 // the meanwhile.
 func (mgr *Manager) performShutdown() {
 	defer func() {
-		for _, ch := range mgr.subscriptions {
-			mgr.removeSubscription(ch)
+		for _, sub := range mgr.subscriptions {
+			mgr.removeSubscription(sub.ch)
 		}
 
 		close(mgr.didShutdown)
 	}()
 
+	// the services stopped by shutdown are not started again
+	mgr.restarting = make(map[Service]int)
+
+	if mgr.handingOver {
+		mgr.handedOver = mgr.running
+		mgr.running = make(map[Service][]Handle)
+	}
+
 	if len(mgr.running) == 0 {
 		return
 	}
 
-	for _, h := range mgr.running {
-		h.Stop()
+	for svc := range mgr.running {
+		mgr.stopService(svc)
 	}
 
 loop:
@@ -188,8 +334,8 @@ loop:
 		select {
 		case q := <-mgr.queries:
 			mgr.queryService(q)
-		case svc := <-mgr.stopped:
-			mgr.serviceStopped(svc)
+		case e := <-mgr.stopped:
+			mgr.instanceStopped(e.svc, e.handle, e.err)
 
 			if len(mgr.running) == 0 {
 				break loop
@@ -198,16 +344,21 @@ loop:
 	}
 }
 
-// waitHandle asynchronously writes the service back to stopped when Handle.Wait returns.
+// waitHandle asynchronously writes the service's instance back to stopped when Handle.Wait returns.
 func (mgr *Manager) waitHandle(handle Handle, svc Service) {
 	go func() {
-		_ = handle.Wait()
-		mgr.stopped <- svc
+		err := handle.Wait()
+
+		select {
+		case mgr.stopped <- exit{svc: svc, handle: handle, err: err}:
+		case <-mgr.didShutdown:
+			// the service was handed over, no one's tracking it anymore
+		}
 	}()
 }
 
-// Start starts the Services if they are not running. It does nothing if called after shutdown was initiate by
-// a call to Shutdown.
+// Start starts one instance of the Services if they are not running. It does nothing if called after shutdown was
+// initiate by a call to Shutdown.
 func (mgr *Manager) Start(services ...Service) {
 	select {
 	case <-mgr.shutdown:
@@ -218,38 +369,155 @@ func (mgr *Manager) Start(services ...Service) {
 	}
 }
 
-// startService starts the Service, adding it to running only if it starts without error.
+// startService starts one instance of the Service, unless it's running.
 func (mgr *Manager) startService(svc Service) {
 	if _, ok := mgr.running[svc]; ok {
 		return
 	}
 
-	handle, err := svc.Start()
-	if err != nil {
+	mgr.scaleService(svc, 1)
+}
+
+// Scale starts or stops instances of the Services so that n of them run, stopping the Services if n is zero. The
+// instances started last are stopped first. It does nothing if called after shutdown.
+func (mgr *Manager) Scale(n int, services ...Service) {
+	select {
+	case <-mgr.shutdown:
+	default:
+		for _, svc := range services {
+			mgr.scale <- scaling{svc: svc, n: n}
+		}
+	}
+}
+
+// scaleService starts or stops instances of the Service until n of them run, and aren't stopping. Instances are
+// added to running only if they start without error.
+func (mgr *Manager) scaleService(svc Service, n int) {
+	if n < 1 {
+		mgr.stopService(svc)
+		return
+	}
+
+	wasRunning := len(mgr.running[svc]) > 0
+	active := mgr.activeHandles(svc)
+
+	if len(active) == n {
 		return
 	}
 
-	mgr.running[svc] = handle
-	mgr.waitHandle(handle, svc)
-	mgr.notifySubscribers(svc, true)
+	if !wasRunning {
+		mgr.notifySubscribers(Change{Service: svc, State: StateStarting})
+	}
+
+	var err error
+
+	for len(active) < n {
+		var handle Handle
+		if handle, err = svc.Start(); err != nil {
+			break
+		}
+
+		mgr.running[svc] = append(mgr.running[svc], handle)
+		mgr.waitHandle(handle, svc)
+		active = append(active, handle)
+	}
+
+	for len(active) > n {
+		handle := active[len(active)-1]
+		mgr.stopping[handle] = true
+		handle.Stop()
+		active = active[:len(active)-1]
+	}
+
+	if len(mgr.running[svc]) == 0 {
+		delete(mgr.running, svc)
+		mgr.notifySubscribers(Change{Service: svc, Err: err, State: StateStopped})
+		return
+	}
+
+	mgr.notifySubscribers(Change{Service: svc, Running: true, State: StateRunning, Err: err,
+		Instances: len(active), Scaled: wasRunning})
 }
 
-// Running reports on the current running state of a service.
+// activeHandles returns the Handles of the instances of the Service that are not stopping.
+func (mgr *Manager) activeHandles(svc Service) []Handle {
+	var list []Handle
+	for _, handle := range mgr.running[svc] {
+		if !mgr.stopping[handle] {
+			list = append(list, handle)
+		}
+	}
+
+	return list
+}
+
+// Adopt tracks already running instances of svc, e.g. inherited from a previous hkswitch process, as if they were
+// started by Scale. The Handles are stopped if svc is already running. It does nothing if called after shutdown.
+func (mgr *Manager) Adopt(svc Service, handles ...Handle) {
+	select {
+	case <-mgr.shutdown:
+	default:
+		mgr.adopt <- adoption{svc: svc, handles: handles}
+	}
+}
+
+// adoptService adds the Handles to running, unless the service is already running.
+func (mgr *Manager) adoptService(svc Service, handles []Handle) {
+	if _, ok := mgr.running[svc]; ok || len(handles) == 0 {
+		for _, handle := range handles {
+			handle.Stop()
+		}
+
+		return
+	}
+
+	mgr.running[svc] = handles
+	for _, handle := range handles {
+		mgr.waitHandle(handle, svc)
+	}
+
+	mgr.notifySubscribers(Change{Service: svc, Running: true, State: StateRunning, Instances: len(handles)})
+}
+
+// Running reports on the current running state of a service, which includes a service that is stopping.
 func (mgr *Manager) Running(svc Service) bool {
+	state := mgr.State(svc)
+	return state == StateRunning || state == StateStopping
+}
+
+// State returns the current state of a service.
+func (mgr *Manager) State(svc Service) State {
+	return mgr.status(svc).state
+}
+
+// Instances returns the number of instances of a service running and not asked to stop.
+func (mgr *Manager) Instances(svc Service) int {
+	return mgr.status(svc).instances
+}
+
+func (mgr *Manager) status(svc Service) status {
 	q := newQuery(svc)
 
 	select {
 	case <-mgr.didShutdown:
-		return false
+		return status{state: StateStopped}
 	case mgr.queries <- q:
 		return <-q.reply
 	}
 }
 
-// queryService checks if a service is running and writes the state to query.reply.
+// queryService checks the state of a service and writes it to query.reply.
 func (mgr *Manager) queryService(q query) {
-	_, ok := mgr.running[q.svc]
-	q.reply <- ok
+	active := len(mgr.activeHandles(q.svc))
+
+	switch _, ok := mgr.running[q.svc]; {
+	case ok && active == 0:
+		q.reply <- status{state: StateStopping}
+	case ok:
+		q.reply <- status{state: StateRunning, instances: active}
+	default:
+		q.reply <- status{state: StateStopped}
+	}
 }
 
 // Stop stops one or more services. Has no effect when called after Shutdown, as all running services will be
@@ -264,18 +532,86 @@ func (mgr *Manager) Stop(services ...Service) {
 	}
 }
 
-// stopService stops the services if it is running.
+// Restart stops one or more running services and starts them again once they have stopped. Services that are not
+// running are left stopped. Has no effect when called after Shutdown.
+func (mgr *Manager) Restart(services ...Service) {
+	select {
+	case <-mgr.shutdown:
+	default:
+		for _, svc := range services {
+			mgr.restart <- svc
+		}
+	}
+}
+
+// restartService stops the service if it is running, marking it to be started again, with as many instances, when
+// it stops.
+func (mgr *Manager) restartService(svc Service) {
+	if _, ok := mgr.running[svc]; ok {
+		if n := len(mgr.activeHandles(svc)); n > mgr.restarting[svc] {
+			mgr.restarting[svc] = n
+		}
+
+		mgr.stopService(svc)
+	}
+}
+
+// stopService stops all instances of the services if it is running.
 func (mgr *Manager) stopService(svc Service) {
-	if handle, ok := mgr.running[svc]; ok {
+	if len(mgr.activeHandles(svc)) > 0 {
+		mgr.notifySubscribers(Change{Service: svc, Running: true, State: StateStopping})
+	}
+
+	for _, handle := range mgr.running[svc] {
+		mgr.stopping[handle] = true
 		handle.Stop()
 	}
 }
 
-// serviceStopped removes the service from running.
-func (mgr *Manager) serviceStopped(svc Service) {
-	if _, ok := mgr.running[svc]; ok {
-		delete(mgr.running, svc)
-		mgr.notifySubscribers(svc, false)
+// instanceStopped removes the instance of the service from running. When it was the last one, the service is
+// stopped, and started again if it was restarting.
+func (mgr *Manager) instanceStopped(svc Service, handle Handle, err error) {
+	var remaining []Handle
+	var found bool
+
+	for _, h := range mgr.running[svc] {
+		if h == handle {
+			found = true
+		} else {
+			remaining = append(remaining, h)
+		}
+	}
+
+	if !found {
+		return
+	}
+
+	requested := mgr.stopping[handle]
+	delete(mgr.stopping, handle)
+
+	if len(remaining) > 0 {
+		mgr.running[svc] = remaining
+
+		active := len(mgr.activeHandles(svc))
+		state := StateRunning
+		if active == 0 {
+			state = StateStopping
+		}
+
+		mgr.notifySubscribers(Change{Service: svc, Running: true, State: state, Err: err, Requested: requested,
+			Instances: active, Scaled: true})
+
+		return
+	}
+
+	restart := mgr.restarting[svc]
+
+	delete(mgr.running, svc)
+	delete(mgr.restarting, svc)
+	mgr.notifySubscribers(Change{Service: svc, Err: err, Requested: requested, State: StateStopped})
+
+	if restart > 0 {
+		mgr.scaleService(svc, restart)
 	}
 }
 
@@ -290,6 +626,20 @@ func (mgr *Manager) Shutdown() {
 	}
 }
 
+// Handover stops the Manager like Shutdown, but leaves the running services running and returns the Handles of their
+// instances, so that they can be handed over to another process. It returns nil if shutdown was already initiated.
+func (mgr *Manager) Handover() map[Service][]Handle {
+	select {
+	case <-mgr.shutdown:
+		return nil
+	default:
+		mgr.handingOver = true
+		close(mgr.shutdown)
+		<-mgr.didShutdown
+		return mgr.handedOver
+	}
+}
+
 type daemon struct {
 	name string
 	cmd  *Command
@@ -318,3 +668,16 @@ func (s *daemon) Start() (Handle, error) {
 func (s *daemon) Name() string {
 	return s.name
 }
+
+func (s *daemon) RunCommand(ctx context.Context, cmd *Command) error {
+	return cmd.Run(ctx, s.stdout, s.stderr)
+}
+
+func (s *daemon) Adopt(d Detached) (Handle, error) {
+	handle, err := s.cmd.Adopt(d, s.stdout, s.stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return handle, nil
+}
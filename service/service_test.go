@@ -60,6 +60,179 @@ func TestManager_StartStop(t *testing.T) {
 	}
 }
 
+func TestManager_Start_WithError(t *testing.T) {
+	s1 := &fakeService{name: "s1", startErr: fmt.Errorf("failed")}
+
+	mgr := NewManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := mgr.Subscribe(ctx)
+
+	mgr.Start(s1)
+	change := <-subscription
+
+	if got, want := change.Running, false; got != want {
+		t.Fatalf("Change.Running: got = %v, want = %v", got, want)
+	}
+
+	if got, want := change.Err, s1.startErr; got != want {
+		t.Fatalf("Change.Err: got = %v, want = %v", got, want)
+	}
+}
+
+func TestManager_Stop_Requested(t *testing.T) {
+	s1 := &fakeService{name: "s1"}
+
+	mgr := NewManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := mgr.Subscribe(ctx)
+
+	mgr.Start(s1)
+	<-subscription
+
+	mgr.Stop(s1)
+	change := <-subscription
+
+	if got, want := change.Requested, true; got != want {
+		t.Fatalf("Change.Requested after Stop: got = %v, want = %v", got, want)
+	}
+
+	mgr.Start(s1)
+	<-subscription
+
+	// the service stops on its own
+	s1.handle.Stop()
+	change = <-subscription
+
+	if got, want := change.Requested, false; got != want {
+		t.Fatalf("Change.Requested: got = %v, want = %v", got, want)
+	}
+}
+
+func TestManager_SubscribeTransitions(t *testing.T) {
+	s1 := &fakeService{name: "s1", stopDelay: 50 * time.Millisecond}
+
+	mgr := NewManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := mgr.SubscribeTransitions(ctx)
+
+	mgr.Start(s1)
+
+	for _, want := range []State{StateStarting, StateRunning} {
+		if got := (<-subscription).State; got != want {
+			t.Fatalf("Change.State after Start: got = %v, want = %v", got, want)
+		}
+	}
+
+	mgr.Stop(s1)
+
+	if got, want := (<-subscription).State, StateStopping; got != want {
+		t.Fatalf("Change.State after Stop: got = %v, want = %v", got, want)
+	}
+
+	if got, want := mgr.State(s1), StateStopping; got != want {
+		t.Fatalf("State(): got = %v, want = %v", got, want)
+	}
+
+	if got, want := (<-subscription).State, StateStopped; got != want {
+		t.Fatalf("Change.State after Stop: got = %v, want = %v", got, want)
+	}
+
+	if got, want := mgr.State(s1), StateStopped; got != want {
+		t.Fatalf("State(): got = %v, want = %v", got, want)
+	}
+}
+
+func TestManager_Restart(t *testing.T) {
+	s1 := &fakeService{name: "s1"}
+	s2 := &fakeService{name: "s2"}
+
+	mgr := NewManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := mgr.Subscribe(ctx)
+
+	mgr.Start(s1)
+	<-subscription
+
+	mgr.Restart(s1, s2)
+
+	if got, want := (<-subscription).Running, false; got != want {
+		t.Fatalf("Change.Running after Restart: got = %v, want = %v", got, want)
+	}
+
+	if got, want := (<-subscription).Running, true; got != want {
+		t.Fatalf("Change.Running after Restart: got = %v, want = %v", got, want)
+	}
+
+	if got, want := s1.starts, int32(2); got != want {
+		t.Fatalf("Restart(): got Service.Start() called %d times, want %d", got, want)
+	}
+
+	// not running, left stopped
+	if got, want := s2.starts, int32(0); got != want {
+		t.Fatalf("Restart(): got Service.Start() called %d times, want %d", got, want)
+	}
+}
+
+func TestManager_Scale(t *testing.T) {
+	s1 := &fakeService{name: "s1"}
+
+	mgr := NewManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := mgr.Subscribe(ctx)
+
+	mgr.Scale(3, s1)
+	change := <-subscription
+
+	if got, want := change.Instances, 3; got != want {
+		t.Fatalf("Change.Instances after Scale(3): got = %v, want = %v", got, want)
+	}
+
+	if got, want := change.Scaled, false; got != want {
+		t.Fatalf("Change.Scaled after Scale(3): got = %v, want = %v", got, want)
+	}
+
+	mgr.Scale(1, s1)
+	<-subscription
+
+	if got, want := mgr.Instances(s1), 1; got != want {
+		t.Fatalf("Instances() after Scale(1): got = %v, want = %v", got, want)
+	}
+
+	// the two instances stopping
+	for i := 0; i < 2; i++ {
+		change = <-subscription
+
+		if got, want := change.Scaled && change.Running && change.Requested, true; got != want {
+			t.Fatalf("Change after Scale(1): got = %+v, want scaled, running and requested", change)
+		}
+	}
+
+	mgr.Scale(0, s1)
+
+	if got, want := (<-subscription).Running, false; got != want {
+		t.Fatalf("Change.Running after Scale(0): got = %v, want = %v", got, want)
+	}
+
+	if got, want := s1.starts, int32(3); got != want {
+		t.Fatalf("Scale(): got Service.Start() called %d times, want %d", got, want)
+	}
+}
+
 func TestManager_Start_AfterShutdown(t *testing.T) {
 	s1 := &fakeService{name: "s1"}
 
@@ -151,6 +324,75 @@ func TestManager_Subscribe_AfterShutdown(t *testing.T) {
 	assertReadAtMost(subscription2, 0, t)
 }
 
+func TestManager_Handover(t *testing.T) {
+	s1 := &fakeService{name: "s1"}
+	s2 := &fakeService{name: "s2"}
+
+	mgr := NewManager()
+
+	subscription := mgr.Subscribe(context.Background())
+
+	mgr.Start(s1)
+	<-subscription
+
+	handles := mgr.Handover()
+
+	if got, want := len(handles), 1; got != want {
+		t.Fatalf("Handover(): got %d handles, want %d", got, want)
+	}
+
+	select {
+	case <-handles[s1][0].(*fakeHandle).done:
+		t.Fatalf("Handover(): got handle stopped, want handle running")
+	default:
+	}
+
+	mgr.Start(s2)
+
+	if got, want := s2.starts, int32(0); got != want {
+		t.Fatalf("Start() = %d, want %d effective calls to Service.Start", got, want)
+	}
+
+	if got := mgr.Handover(); got != nil {
+		t.Fatalf("Handover(): got %v, want nil after shutdown", got)
+	}
+}
+
+func TestManager_Adopt(t *testing.T) {
+	s1 := &fakeService{name: "s1"}
+
+	mgr := NewManager()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	subscription := mgr.Subscribe(ctx)
+
+	h1 := &fakeHandle{done: make(chan struct{})}
+	mgr.Adopt(s1, h1)
+	<-subscription
+
+	if got, want := mgr.Running(s1), true; got != want {
+		t.Fatalf("Running(): got = %v, want = %v", got, want)
+	}
+
+	// adopting another instance of a running service stops it
+	h2 := &fakeHandle{done: make(chan struct{})}
+	mgr.Adopt(s1, h2)
+	<-h2.done
+
+	mgr.Stop(s1)
+	<-subscription
+
+	if got, want := mgr.Running(s1), false; got != want {
+		t.Fatalf("Running(): got = %v, want = %v", got, want)
+	}
+
+	if got, want := s1.starts, int32(0); got != want {
+		t.Fatalf("Start(): got Service.Start() called %d times, want Service.Start() called %d times", got, want)
+	}
+}
+
 func assertReadAtMost(ch <-chan Change, atMost int, t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer cancel()
@@ -208,6 +450,10 @@ type fakeService struct {
 	name      string
 	starts    int32
 	stopDelay time.Duration
+	startErr  error
+
+	// handle is the Handle returned by the last call to Start.
+	handle *fakeHandle
 }
 
 func (f *fakeService) Name() string {
@@ -220,5 +466,12 @@ func (f *fakeService) String() string {
 
 func (f *fakeService) Start() (Handle, error) {
 	atomic.AddInt32(&f.starts, 1)
-	return &fakeHandle{done: make(chan struct{}), stopDelay: f.stopDelay}, nil
+
+	if f.startErr != nil {
+		return nil, f.startErr
+	}
+
+	f.handle = &fakeHandle{done: make(chan struct{}), stopDelay: f.stopDelay}
+
+	return f.handle, nil
 }
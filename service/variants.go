@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Variant is one of the programs a service can run, e.g. the same server started with different arguments.
+type Variant struct {
+	Name string
+	Cmd  *Command
+}
+
+// Selector is implemented by Services that run one of several variants of their program.
+type Selector interface {
+	// Variants returns the names of the variants.
+	Variants() []string
+
+	// Selected returns the name of the variant started by Start.
+	Selected() string
+
+	// Select chooses the variant started by the next call to Start, returning false if there is no variant with
+	// that name. It doesn't affect the variant already running, if any.
+	Select(variant string) bool
+}
+
+type variantDaemon struct {
+	name     string
+	variants []Variant
+
+	stdout io.Writer
+	stderr io.Writer
+
+	mu       sync.Mutex
+	selected int
+}
+
+// NewVariantDaemon returns a Service, which is also a Selector, running one of variants at a time, the first one
+// until another is selected.
+func NewVariantDaemon(name string, variants []Variant, stdout, stderr io.Writer) Service {
+	return &variantDaemon{name: name, variants: variants, stdout: stdout, stderr: stderr}
+}
+
+func (s *variantDaemon) String() string {
+	return s.name
+}
+
+func (s *variantDaemon) Name() string {
+	return s.name
+}
+
+func (s *variantDaemon) Start() (Handle, error) {
+	handle, err := s.command().Start(s.stdout, s.stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return handle, nil
+}
+
+// Adopt adopts the program as if it was the selected variant: select the variant it runs first.
+func (s *variantDaemon) Adopt(d Detached) (Handle, error) {
+	handle, err := s.command().Adopt(d, s.stdout, s.stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	return handle, nil
+}
+
+func (s *variantDaemon) RunCommand(ctx context.Context, cmd *Command) error {
+	return cmd.Run(ctx, s.stdout, s.stderr)
+}
+
+func (s *variantDaemon) Variants() []string {
+	list := make([]string, 0, len(s.variants))
+	for _, v := range s.variants {
+		list = append(list, v.Name)
+	}
+
+	return list
+}
+
+func (s *variantDaemon) Selected() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.variants[s.selected].Name
+}
+
+func (s *variantDaemon) Select(variant string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, v := range s.variants {
+		if v.Name == variant {
+			s.selected = i
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *variantDaemon) command() *Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.variants[s.selected].Cmd
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVariantDaemon_Select(t *testing.T) {
+	stdout := &bytes.Buffer{}
+
+	svc := NewVariantDaemon("test", []Variant{
+		{Name: "small", Cmd: &Command{Path: "echo", Args: []string{"small"}}},
+		{Name: "large", Cmd: &Command{Path: "echo", Args: []string{"large"}}},
+	}, stdout, &bytes.Buffer{})
+
+	selector, ok := svc.(Selector)
+	if !ok {
+		t.Fatalf("NewVariantDaemon(): got %T, want a Selector", svc)
+	}
+
+	if got, want := selector.Selected(), "small"; got != want {
+		t.Fatalf("Selected(): got = %q, want = %q", got, want)
+	}
+
+	if got, want := selector.Select("medium"), false; got != want {
+		t.Fatalf("Select(%q): got = %v, want = %v", "medium", got, want)
+	}
+
+	if got, want := selector.Select("large"), true; got != want {
+		t.Fatalf("Select(%q): got = %v, want = %v", "large", got, want)
+	}
+
+	handle, err := svc.Start()
+	if err != nil {
+		t.Fatalf("Start(): got = %v, want nil error", err)
+	}
+
+	if err := handle.Wait(); err != nil {
+		t.Fatalf("Wait(): got = %v, want nil error", err)
+	}
+
+	if got, want := strings.TrimSpace(stdout.String()), "large"; got != want {
+		t.Fatalf("Start(): got output %q, want %q", got, want)
+	}
+}